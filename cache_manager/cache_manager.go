@@ -0,0 +1,318 @@
+package cache_manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+const tmpDirName = ".tmp"
+
+// ErrNoDir 表示 Options.Dir 为空(或指向 ${cache} 本身), 此时缓存会管理并清理 ${cache} 下所有组件的文件
+var ErrNoDir = errors.New("cache_manager: Options.Dir must name a directory under ${cache}")
+
+// ErrComputePanicked 表示 GetOrCompute 的 fn 发生了 panic, 同时等待该 key 的调用者都会得到此错误
+var ErrComputePanicked = errors.New("cache_manager: compute panicked")
+
+type Options struct {
+	// 缓存位于 ${cache}/Dir 下, 不能为空
+	Dir string
+	// 缓存总大小上限(字节), 超出时按最近最少使用的顺序清理, <=0 表示不限制
+	MaxSize int64
+	// 超过 MaxAge 未被访问的缓存会被清理, <=0 表示不限制
+	MaxAge time.Duration
+}
+
+type entry struct {
+	size       int64
+	lastAccess time.Time
+}
+
+type call struct {
+	done chan struct{}
+	err  error
+}
+
+// CacheManager 管理 ${cache} 下的派生文件(下载的资源, 渲染的图片等)
+// 每个 key 对应一个文件, 文件名为 key 的哈希, 最近访问时间保存为文件的修改时间, 因此重启后 LRU 顺序依然有效
+// Get/GetOrCompute 返回的文件在调用 release 之前不会被清理, 因此可以在并发的计算和清理中安全地读取
+// 例外是超出 storage 的 ${cache} 配额时文件可能被删除, 此后的 Get/GetOrCompute 会将其视为不存在
+type CacheManager struct {
+	root string
+	opts Options
+
+	mu       sync.Mutex
+	entries  map[string]*entry
+	pinned   map[string]int
+	inflight map[string]*call
+	total    int64
+	tmpSeq   atomic.Uint64
+}
+
+func NewCacheManager(storage neomega_backbone.StorageAndPathAccess, opts Options) (*CacheManager, error) {
+	root := storage.GetOmegaCachePath(opts.Dir)
+	if root == storage.GetOmegaCachePath() {
+		return nil, ErrNoDir
+	}
+	c := &CacheManager{
+		root:     root,
+		opts:     opts,
+		entries:  map[string]*entry{},
+		pinned:   map[string]int{},
+		inflight: map[string]*call{},
+	}
+	// 上次未完成的计算结果
+	os.RemoveAll(filepath.Join(c.root, tmpDirName))
+	if err := os.MkdirAll(filepath.Join(c.root, tmpDirName), 0755); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(c.root)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		c.entries[file.Name()] = &entry{size: info.Size(), lastAccess: info.ModTime()}
+		c.total += info.Size()
+	}
+	c.mu.Lock()
+	c.evictLocked()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func idOf(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Path 返回 key 对应的缓存文件路径, 无论其是否存在
+func (c *CacheManager) Path(key string) string {
+	return filepath.Join(c.root, idOf(key))
+}
+
+// touch 调用者需要持有锁
+func (c *CacheManager) touch(id string, e *entry) {
+	now := time.Now()
+	e.lastAccess = now
+	os.Chtimes(filepath.Join(c.root, id), now, now)
+}
+
+// existsLocked 检查 id 对应的文件是否还在, 文件可能被 storage 的缓存配额清理, 此时移除其记录, 调用者需要持有锁
+func (c *CacheManager) existsLocked(id string, e *entry) bool {
+	if _, err := os.Stat(filepath.Join(c.root, id)); err == nil {
+		return true
+	}
+	delete(c.entries, id)
+	c.total -= e.size
+	return false
+}
+
+// pinLocked 使 id 在返回的 release 被调用之前不会被清理, release 可以被重复调用, 调用者需要持有锁
+func (c *CacheManager) pinLocked(id string) (release func()) {
+	c.pinned[id]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.unpinLocked(id)
+		})
+	}
+}
+
+// Get 返回 key 对应的缓存文件, 并更新其访问时间
+// 文件在 release 被调用之前不会被清理, 使用完毕后需要调用 release
+func (c *CacheManager) Get(key string) (path string, release func(), found bool) {
+	id := idOf(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok || !c.existsLocked(id, e) {
+		return "", nil, false
+	}
+	c.touch(id, e)
+	return filepath.Join(c.root, id), c.pinLocked(id), true
+}
+
+// GetOrCompute 返回 key 对应的缓存文件, 不存在时调用 fn 将结果写入 dst 生成
+// 同一个 key 同时只会有一个 fn 在执行, 其他调用者等待其结果
+// fn 写入的是一个临时文件, 成功后才会被 rename 到缓存中, 因此不会读到写了一半的文件
+// 与 Get 相同, 使用完毕后需要调用 release
+func (c *CacheManager) GetOrCompute(key string, fn func(dst string) error) (path string, release func(), err error) {
+	id := idOf(key)
+	for {
+		c.mu.Lock()
+		if e, ok := c.entries[id]; ok && c.existsLocked(id, e) {
+			c.touch(id, e)
+			release = c.pinLocked(id)
+			c.mu.Unlock()
+			return filepath.Join(c.root, id), release, nil
+		}
+		if inflight, ok := c.inflight[id]; ok {
+			c.mu.Unlock()
+			<-inflight.done
+			if inflight.err != nil {
+				return "", nil, inflight.err
+			}
+			// 结果可能在等待期间已被清理, 重新查找并 pin
+			continue
+		}
+		current := &call{done: make(chan struct{})}
+		c.inflight[id] = current
+		c.mu.Unlock()
+
+		return c.computeCall(key, id, current, fn)
+	}
+}
+
+// computeCall 执行 current 对应的计算, fn 的 panic 被转换为 ErrComputePanicked
+// 无论结果如何都会移除 current 并唤醒等待它的调用者
+func (c *CacheManager) computeCall(key, id string, current *call, fn func(dst string) error) (path string, release func(), err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			path, release, err = "", nil, fmt.Errorf("%w: %v: %v", ErrComputePanicked, key, r)
+		}
+		current.err = err
+		c.mu.Lock()
+		delete(c.inflight, id)
+		c.mu.Unlock()
+		close(current.done)
+	}()
+	return c.compute(id, fn)
+}
+
+func (c *CacheManager) compute(id string, fn func(dst string) error) (string, func(), error) {
+	tmp := filepath.Join(c.root, tmpDirName, id+"-"+strconv.FormatUint(c.tmpSeq.Add(1), 10))
+	defer os.Remove(tmp)
+	if err := fn(tmp); err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return "", nil, err
+	}
+	dst := filepath.Join(c.root, id)
+	if err := os.Rename(tmp, dst); err != nil {
+		return "", nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[id]; ok {
+		c.total -= old.size
+	}
+	c.entries[id] = &entry{size: info.Size(), lastAccess: time.Now()}
+	c.total += info.Size()
+	// 刚生成的文件在交给调用者之前就被 pin, 不会被本次清理删除
+	release := c.pinLocked(id)
+	c.evictLocked()
+	return dst, release, nil
+}
+
+// GetOrComputeBytes 与 GetOrCompute 相同, 但直接读写文件内容
+func (c *CacheManager) GetOrComputeBytes(key string, fn func() ([]byte, error)) ([]byte, error) {
+	path, release, err := c.GetOrCompute(key, func(dst string) error {
+		data, err := fn()
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dst, data, 0644)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return os.ReadFile(path)
+}
+
+// Pin 使 key 对应的缓存不会被清理, 直到对应次数的 Unpin 被调用
+func (c *CacheManager) Pin(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pinned[idOf(key)]++
+}
+
+func (c *CacheManager) Unpin(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unpinLocked(idOf(key))
+}
+
+func (c *CacheManager) unpinLocked(id string) {
+	if c.pinned[id] <= 1 {
+		delete(c.pinned, id)
+	} else {
+		c.pinned[id]--
+	}
+}
+
+// Remove 删除 key 对应的缓存, 即使它被 Pin 或者正在被读取
+func (c *CacheManager) Remove(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.removeLocked(idOf(key))
+}
+
+func (c *CacheManager) removeLocked(id string) error {
+	e, ok := c.entries[id]
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(c.root, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(c.entries, id)
+	c.total -= e.size
+	return nil
+}
+
+// Size 返回缓存的总大小
+func (c *CacheManager) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// Evict 按 Options 清理缓存, 被 Pin 的缓存不会被清理
+func (c *CacheManager) Evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictLocked()
+}
+
+func (c *CacheManager) evictLocked() {
+	ids := make([]string, 0, len(c.entries))
+	for id := range c.entries {
+		if c.pinned[id] == 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return c.entries[ids[i]].lastAccess.Before(c.entries[ids[j]].lastAccess)
+	})
+	now := time.Now()
+	for _, id := range ids {
+		tooOld := c.opts.MaxAge > 0 && now.Sub(c.entries[id].lastAccess) > c.opts.MaxAge
+		tooLarge := c.opts.MaxSize > 0 && c.total > c.opts.MaxSize
+		if !tooOld && !tooLarge {
+			continue
+		}
+		c.removeLocked(id)
+	}
+}
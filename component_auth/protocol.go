@@ -0,0 +1,108 @@
+package component_auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+// 组件认证通过 DynamicComponentFactory 收到的 ChallengeFn 进行, 整个过程在 factory 内完成:
+//  1. 组件调用 fn(RequestChallenge), 框架返回挑战 "v1;<alg>;<nonce>"
+//  2. 组件对 signedMessage(挑战) 计算证明, 调用 fn("v1;proof;<nonce>;<base64(证明)>"), 框架返回 Accepted 或 "rejected;<原因>"
+//  3. factory 返回后, 框架检查该会话是否认证成功, 决定是否接受该组件
+// alg 为 hmac-sha256(证明为以来源的共享密钥计算的 HMAC) 或 ed25519(证明为以来源的私钥计算的签名)
+// 每个 nonce 只能被尝试一次且有有效期, 因此截获的证明无法被重放
+
+const (
+	protocolVersion = "v1"
+
+	AlgHMACSHA256 = "hmac-sha256"
+	AlgEd25519    = "ed25519"
+
+	RequestChallenge = protocolVersion + ";challenge"
+	Accepted         = "accepted"
+	rejectedPrefix   = "rejected;"
+)
+
+var (
+	ErrNotAuthenticated = errors.New("component_auth: component not authenticated")
+	ErrRejected         = errors.New("component_auth: proof rejected")
+	ErrBadMessage       = errors.New("component_auth: malformed message")
+	ErrInvalidKey       = errors.New("component_auth: invalid key")
+)
+
+// signedMessage 是实际被 HMAC 或签名的内容, 包含来源和组件名, 防止为一个组件计算的证明被用于另一个组件
+func signedMessage(source, name, nonce string) []byte {
+	return []byte("neomega-component-auth|" + protocolVersion + "|" + source + "|" + name + "|" + nonce)
+}
+
+// Prover 由组件一侧持有, 用于计算证明
+type Prover interface {
+	Alg() string
+	Prove(message []byte) []byte
+}
+
+type hmacProver struct {
+	secret []byte
+}
+
+func (p *hmacProver) Alg() string { return AlgHMACSHA256 }
+
+func (p *hmacProver) Prove(message []byte) []byte {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func NewHMACProver(secret []byte) Prover {
+	return &hmacProver{secret: append([]byte{}, secret...)}
+}
+
+type ed25519Prover struct {
+	key ed25519.PrivateKey
+}
+
+func (p *ed25519Prover) Alg() string { return AlgEd25519 }
+
+func (p *ed25519Prover) Prove(message []byte) []byte {
+	return ed25519.Sign(p.key, message)
+}
+
+func NewEd25519Prover(key ed25519.PrivateKey) Prover {
+	return &ed25519Prover{key: key}
+}
+
+// Authenticate 在组件的 factory 中调用, 通过 fn 完成认证
+// source 和 name 为框架给该组件的来源和名称(name 即 factory 的第一个参数)
+func Authenticate(fn neomega_backbone.ChallengeFn, source, name string, prover Prover) error {
+	if fn == nil {
+		return ErrNotAuthenticated
+	}
+	challenge := fn(RequestChallenge)
+	if strings.HasPrefix(challenge, rejectedPrefix) {
+		return fmt.Errorf("%w: %v", ErrRejected, strings.TrimPrefix(challenge, rejectedPrefix))
+	}
+	parts := strings.Split(challenge, ";")
+	if len(parts) != 3 || parts[0] != protocolVersion {
+		return fmt.Errorf("%w: %q", ErrBadMessage, challenge)
+	}
+	alg, nonce := parts[1], parts[2]
+	if alg != prover.Alg() {
+		return fmt.Errorf("component_auth: frame requires %v, but prover uses %v", alg, prover.Alg())
+	}
+	proof := prover.Prove(signedMessage(source, name, nonce))
+	result := fn(strings.Join([]string{protocolVersion, "proof", nonce, base64.StdEncoding.EncodeToString(proof)}, ";"))
+	if result == Accepted {
+		return nil
+	}
+	if strings.HasPrefix(result, rejectedPrefix) {
+		return fmt.Errorf("%w: %v", ErrRejected, strings.TrimPrefix(result, rejectedPrefix))
+	}
+	return fmt.Errorf("%w: %q", ErrBadMessage, result)
+}
@@ -0,0 +1,208 @@
+package component_auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+// 每个会话中同时存在的挑战数上限
+const maxOutstanding = 8
+
+type sourceKey struct {
+	hmacSecret []byte
+	publicKey  ed25519.PublicKey
+}
+
+// Verifier 由框架持有, 记录每个来源的密钥, 并为每次组件创建建立独立的认证会话
+type Verifier struct {
+	// 挑战的有效期
+	ttl time.Duration
+	now func() time.Time
+
+	mu   sync.RWMutex
+	keys map[string]sourceKey
+}
+
+func NewVerifier(challengeTTL time.Duration) *Verifier {
+	return &Verifier{
+		ttl:  challengeTTL,
+		now:  time.Now,
+		keys: map[string]sourceKey{},
+	}
+}
+
+// SetHMACSecret 设置来源 source 的共享密钥, 会覆盖该来源之前设置的公钥, secret 不能为空
+func (v *Verifier) SetHMACSecret(source string, secret []byte) error {
+	if len(secret) == 0 {
+		return fmt.Errorf("%w: empty hmac secret for source %v", ErrInvalidKey, source)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys[source] = sourceKey{hmacSecret: append([]byte{}, secret...)}
+	return nil
+}
+
+// SetEd25519PublicKey 设置来源 source 的公钥, 会覆盖该来源之前设置的共享密钥, publicKey 的长度必须为 ed25519.PublicKeySize
+func (v *Verifier) SetEd25519PublicKey(source string, publicKey ed25519.PublicKey) error {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: ed25519 public key for source %v has %v bytes", ErrInvalidKey, source, len(publicKey))
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys[source] = sourceKey{publicKey: append(ed25519.PublicKey{}, publicKey...)}
+	return nil
+}
+
+func (v *Verifier) RemoveSource(source string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.keys, source)
+}
+
+func (v *Verifier) key(source string) (sourceKey, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	key, ok := v.keys[source]
+	return key, ok
+}
+
+// Session 是一次组件创建过程中的认证状态
+type Session struct {
+	verifier *Verifier
+	source   string
+	name     string
+
+	mu sync.Mutex
+	// 尚未被尝试的挑战, nonce -> 过期时间
+	outstanding   map[string]time.Time
+	authenticated bool
+	lastErr       error
+}
+
+func (v *Verifier) NewSession(source, name string) *Session {
+	return &Session{
+		verifier:    v,
+		source:      source,
+		name:        name,
+		outstanding: map[string]time.Time{},
+		lastErr:     ErrNotAuthenticated,
+	}
+}
+
+// ChallengeFn 返回交给 factory 的 ChallengeFn
+func (s *Session) ChallengeFn() neomega_backbone.ChallengeFn {
+	return s.handle
+}
+
+func rejected(reason string) string {
+	return rejectedPrefix + reason
+}
+
+func (s *Session) handle(message string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.verifier.key(s.source)
+	if !ok {
+		s.lastErr = fmt.Errorf("%w: no key for source %v", ErrNotAuthenticated, s.source)
+		return rejected("unknown source")
+	}
+	if message == RequestChallenge {
+		if len(s.outstanding) >= maxOutstanding {
+			return rejected("too many challenges")
+		}
+		nonceBytes := make([]byte, 32)
+		if _, err := rand.Read(nonceBytes); err != nil {
+			s.lastErr = err
+			return rejected("internal error")
+		}
+		nonce := hex.EncodeToString(nonceBytes)
+		s.outstanding[nonce] = s.verifier.now().Add(s.verifier.ttl)
+		alg := AlgHMACSHA256
+		if key.publicKey != nil {
+			alg = AlgEd25519
+		}
+		return strings.Join([]string{protocolVersion, alg, nonce}, ";")
+	}
+	parts := strings.Split(message, ";")
+	if len(parts) != 4 || parts[0] != protocolVersion || parts[1] != "proof" {
+		s.lastErr = fmt.Errorf("%w: %q", ErrBadMessage, message)
+		return rejected("malformed message")
+	}
+	nonce := parts[2]
+	expire, ok := s.outstanding[nonce]
+	// 无论结果如何, 每个 nonce 只能被尝试一次
+	delete(s.outstanding, nonce)
+	if !ok {
+		s.lastErr = fmt.Errorf("%w: unknown or reused challenge", ErrRejected)
+		return rejected("unknown or reused challenge")
+	}
+	if s.verifier.now().After(expire) {
+		s.lastErr = fmt.Errorf("%w: challenge expired", ErrRejected)
+		return rejected("challenge expired")
+	}
+	proof, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		s.lastErr = fmt.Errorf("%w: %q", ErrBadMessage, message)
+		return rejected("malformed proof")
+	}
+	signed := signedMessage(s.source, s.name, nonce)
+	var valid bool
+	if key.publicKey != nil {
+		valid = ed25519.Verify(key.publicKey, signed, proof)
+	} else {
+		mac := hmac.New(sha256.New, key.hmacSecret)
+		mac.Write(signed)
+		valid = hmac.Equal(mac.Sum(nil), proof)
+	}
+	if !valid {
+		s.lastErr = fmt.Errorf("%w: invalid proof for source %v", ErrRejected, s.source)
+		return rejected("invalid proof")
+	}
+	s.authenticated = true
+	s.lastErr = nil
+	return Accepted
+}
+
+// Err 在认证成功后返回 nil, 否则返回未认证的原因
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.authenticated {
+		return nil
+	}
+	return s.lastErr
+}
+
+// CreateComponent 以新的会话调用 factory, 组件未能在 factory 中完成认证时返回错误, 不返回该组件
+func (v *Verifier) CreateComponent(source, name string, factory neomega_backbone.DynamicComponentFactory) (neomega_backbone.DynamicComponent, error) {
+	session := v.NewSession(source, name)
+	component := factory(name, session.ChallengeFn())
+	if err := session.Err(); err != nil {
+		return nil, fmt.Errorf("component_auth: reject %v from %v: %w", name, source, err)
+	}
+	return component, nil
+}
+
+// WrapFactory 返回要求认证的 factory, 传入的 ChallengeFn 会被替换为新会话的, 认证失败时调用 onReject 并返回 nil
+func (v *Verifier) WrapFactory(source string, factory neomega_backbone.DynamicComponentFactory, onReject func(name string, err error)) neomega_backbone.DynamicComponentFactory {
+	return func(name string, _ neomega_backbone.ChallengeFn) neomega_backbone.DynamicComponent {
+		component, err := v.CreateComponent(source, name, factory)
+		if err != nil {
+			if onReject != nil {
+				onReject(name, err)
+			}
+			return nil
+		}
+		return component
+	}
+}
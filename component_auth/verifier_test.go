@@ -0,0 +1,299 @@
+package component_auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+func mustEd25519Key(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+// recordingFn 包装 fn, 记录组件发出的最后一个证明, 以便之后重放
+func recordingFn(fn func(string) string, lastProof *string) func(string) string {
+	return func(message string) string {
+		if strings.HasPrefix(message, protocolVersion+";proof;") {
+			*lastProof = message
+		}
+		return fn(message)
+	}
+}
+
+func TestVerifierRejects(t *testing.T) {
+	const source, name = "插件", "组件"
+	pub, priv := mustEd25519Key(t)
+	otherPub, _ := mustEd25519Key(t)
+	_, otherPriv := mustEd25519Key(t)
+
+	cases := []struct {
+		name string
+		// setup 配置 verifier 的密钥
+		setup func(t *testing.T, v *Verifier)
+		// run 在会话中进行认证, 返回组件一侧看到的错误
+		run func(t *testing.T, v *Verifier, s *Session) error
+		// 认证应当成功
+		accept bool
+	}{
+		{
+			name: "hmac accepted",
+			setup: func(t *testing.T, v *Verifier) {
+				if err := v.SetHMACSecret(source, []byte("secret")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run: func(t *testing.T, v *Verifier, s *Session) error {
+				return Authenticate(s.ChallengeFn(), source, name, NewHMACProver([]byte("secret")))
+			},
+			accept: true,
+		},
+		{
+			name: "ed25519 accepted",
+			setup: func(t *testing.T, v *Verifier) {
+				if err := v.SetEd25519PublicKey(source, pub); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run: func(t *testing.T, v *Verifier, s *Session) error {
+				return Authenticate(s.ChallengeFn(), source, name, NewEd25519Prover(priv))
+			},
+			accept: true,
+		},
+		{
+			name: "wrong hmac secret",
+			setup: func(t *testing.T, v *Verifier) {
+				if err := v.SetHMACSecret(source, []byte("secret")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run: func(t *testing.T, v *Verifier, s *Session) error {
+				return Authenticate(s.ChallengeFn(), source, name, NewHMACProver([]byte("guess")))
+			},
+		},
+		{
+			name: "wrong ed25519 private key",
+			setup: func(t *testing.T, v *Verifier) {
+				if err := v.SetEd25519PublicKey(source, pub); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run: func(t *testing.T, v *Verifier, s *Session) error {
+				return Authenticate(s.ChallengeFn(), source, name, NewEd25519Prover(otherPriv))
+			},
+		},
+		{
+			name: "ed25519 key of another source",
+			setup: func(t *testing.T, v *Verifier) {
+				if err := v.SetEd25519PublicKey(source, otherPub); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run: func(t *testing.T, v *Verifier, s *Session) error {
+				return Authenticate(s.ChallengeFn(), source, name, NewEd25519Prover(priv))
+			},
+		},
+		{
+			name: "replayed nonce in a new session",
+			setup: func(t *testing.T, v *Verifier) {
+				if err := v.SetHMACSecret(source, []byte("secret")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run: func(t *testing.T, v *Verifier, s *Session) error {
+				// 截获另一个会话中被接受的证明, 在本会话中重放
+				var proof string
+				victim := v.NewSession(source, name)
+				if err := Authenticate(recordingFn(victim.ChallengeFn(), &proof), source, name, NewHMACProver([]byte("secret"))); err != nil {
+					t.Fatal(err)
+				}
+				s.ChallengeFn()(RequestChallenge)
+				if result := s.ChallengeFn()(proof); result != Accepted {
+					return errors.New(result)
+				}
+				return nil
+			},
+		},
+		{
+			name: "replayed nonce in the same session",
+			setup: func(t *testing.T, v *Verifier) {
+				if err := v.SetHMACSecret(source, []byte("secret")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run: func(t *testing.T, v *Verifier, s *Session) error {
+				// 先提交错误的证明, 再以同一个 nonce 提交正确的证明
+				fn := s.ChallengeFn()
+				challenge := strings.Split(fn(RequestChallenge), ";")
+				nonce := challenge[2]
+				fn(strings.Join([]string{protocolVersion, "proof", nonce, "AAAA"}, ";"))
+				var proof string
+				prover := NewHMACProver([]byte("secret"))
+				replay := func(message string) string {
+					if message == RequestChallenge {
+						return strings.Join(challenge, ";")
+					}
+					return fn(message)
+				}
+				err := Authenticate(recordingFn(replay, &proof), source, name, prover)
+				if err == nil {
+					t.Fatalf("reused nonce %v accepted", nonce)
+				}
+				return err
+			},
+		},
+		{
+			name: "expired challenge",
+			setup: func(t *testing.T, v *Verifier) {
+				if err := v.SetHMACSecret(source, []byte("secret")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run: func(t *testing.T, v *Verifier, s *Session) error {
+				start := time.Now()
+				v.now = func() time.Time { return start }
+				fn := s.ChallengeFn()
+				delayed := func(message string) string {
+					if message != RequestChallenge {
+						v.now = func() time.Time { return start.Add(time.Minute + time.Second) }
+					}
+					return fn(message)
+				}
+				return Authenticate(delayed, source, name, NewHMACProver([]byte("secret")))
+			},
+		},
+		{
+			name:  "unknown source",
+			setup: func(t *testing.T, v *Verifier) {},
+			run: func(t *testing.T, v *Verifier, s *Session) error {
+				return Authenticate(s.ChallengeFn(), source, name, NewHMACProver([]byte("secret")))
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := NewVerifier(time.Minute)
+			c.setup(t, v)
+			s := v.NewSession(source, name)
+			err := c.run(t, v, s)
+			if c.accept {
+				if err != nil {
+					t.Fatalf("component error: %v", err)
+				}
+				if err := s.Err(); err != nil {
+					t.Fatalf("session error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("component was not told it is rejected")
+			}
+			if err := s.Err(); err == nil {
+				t.Fatal("session accepted")
+			}
+		})
+	}
+}
+
+func TestVerifierRejectsInvalidKeys(t *testing.T) {
+	cases := []struct {
+		name string
+		set  func(v *Verifier) error
+	}{
+		{"empty hmac secret", func(v *Verifier) error { return v.SetHMACSecret("a", nil) }},
+		{"empty ed25519 key", func(v *Verifier) error { return v.SetEd25519PublicKey("a", ed25519.PublicKey{}) }},
+		{"short ed25519 key", func(v *Verifier) error { return v.SetEd25519PublicKey("a", make(ed25519.PublicKey, 16)) }},
+		{"long ed25519 key", func(v *Verifier) error { return v.SetEd25519PublicKey("a", make(ed25519.PublicKey, 64)) }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := NewVerifier(time.Minute)
+			if err := c.set(v); !errors.Is(err, ErrInvalidKey) {
+				t.Fatalf("got %v, want ErrInvalidKey", err)
+			}
+			if _, ok := v.key("a"); ok {
+				t.Fatal("invalid key was stored")
+			}
+		})
+	}
+}
+
+type stubComponent struct{}
+
+func (stubComponent) Init(neomega_backbone.DynamicComponentConfig, neomega_backbone.StorageAndPathAccess) {
+}
+func (stubComponent) Inject(neomega_backbone.ExtendOmega) {}
+func (stubComponent) BeforeActivate() error               { return nil }
+func (stubComponent) Activate()                           {}
+
+// hmacFactory 返回以 secret 认证的组件的 factory, 认证失败时组件依然返回自己, 由框架决定是否接受
+func hmacFactory(source string, secret []byte) neomega_backbone.DynamicComponentFactory {
+	return func(name string, fn neomega_backbone.ChallengeFn) neomega_backbone.DynamicComponent {
+		Authenticate(fn, source, name, NewHMACProver(secret))
+		return stubComponent{}
+	}
+}
+
+func TestCreateComponentRejectsWrongKey(t *testing.T) {
+	const source, name = "插件", "组件"
+	v := NewVerifier(time.Minute)
+	if err := v.SetHMACSecret(source, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	component, err := v.CreateComponent(source, name, hmacFactory(source, []byte("guess")))
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("got %v, want ErrRejected", err)
+	}
+	if component != nil {
+		t.Fatal("rejected component was returned")
+	}
+
+	if component, err := v.CreateComponent(source, name, hmacFactory(source, []byte("secret"))); err != nil || component == nil {
+		t.Fatalf("component with the right secret was rejected: %v", err)
+	}
+}
+
+func TestWrapFactoryRejectsWrongKey(t *testing.T) {
+	const source, name = "插件", "组件"
+	v := NewVerifier(time.Minute)
+	if err := v.SetHMACSecret(source, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	var rejected []string
+	var rejectErr error
+	onReject := func(name string, err error) {
+		rejected = append(rejected, name)
+		rejectErr = err
+	}
+
+	// 框架传入的 ChallengeFn 会被替换, 即使它接受任何响应
+	acceptAll := func(string) string { return "" }
+	if component := v.WrapFactory(source, hmacFactory(source, []byte("guess")), onReject)(name, acceptAll); component != nil {
+		t.Fatal("rejected component was returned")
+	}
+	if len(rejected) != 1 || rejected[0] != name || !errors.Is(rejectErr, ErrRejected) {
+		t.Fatalf("onReject got %v, %v", rejected, rejectErr)
+	}
+
+	if component := v.WrapFactory(source, hmacFactory(source, []byte("secret")), onReject)(name, acceptAll); component == nil {
+		t.Fatal("component with the right secret was rejected")
+	}
+	if len(rejected) != 1 {
+		t.Fatalf("onReject called for an accepted component: %v", rejectErr)
+	}
+
+	// onReject 可以为 nil
+	if component := v.WrapFactory(source, hmacFactory(source, []byte("guess")), nil)(name, acceptAll); component != nil {
+		t.Fatal("rejected component was returned")
+	}
+}
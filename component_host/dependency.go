@@ -0,0 +1,165 @@
+package component_host
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+var ErrUnresolvedDependency = errors.New("component_host: unresolved component dependency")
+
+type DependencyNode struct {
+	Name     string
+	Provides []neomega_backbone.Capability
+	Requires []neomega_backbone.Capability
+}
+
+type MissingProvider struct {
+	Component  string
+	Capability neomega_backbone.Capability
+}
+
+// DependencyError 列出所有缺少提供者的依赖以及所有循环依赖
+type DependencyError struct {
+	Missing []MissingProvider
+	// 每个元素为一个环上的组件, 前一个依赖后一个, 首尾为同一个组件
+	Cycles [][]string
+}
+
+func (e *DependencyError) Error() string {
+	lines := []string{"component_host: unresolved component dependencies:"}
+	for _, m := range e.Missing {
+		lines = append(lines, fmt.Sprintf("  %v requires %v, but no component provides it", m.Component, m.Capability))
+	}
+	for _, cycle := range e.Cycles {
+		lines = append(lines, "  dependency cycle: "+strings.Join(cycle, " requires "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *DependencyError) Unwrap() error {
+	return ErrUnresolvedDependency
+}
+
+func capabilityKey(c neomega_backbone.Capability) neomega_backbone.Capability {
+	c.Optional = false
+	return c
+}
+
+// ResolveOrder 返回 nodes 的启动顺序, 提供者总是排在依赖它的节点之前, 没有依赖关系的节点按名称排序
+// external 为已经可用的能力(e.g. 由框架或已经在运行的组件提供), 依赖它们不会产生顺序约束
+// 存在缺少提供者的(非 Optional)依赖或循环依赖时返回 *DependencyError
+func ResolveOrder(nodes []DependencyNode, external []neomega_backbone.Capability) ([]string, error) {
+	available := map[neomega_backbone.Capability]bool{}
+	for _, c := range external {
+		available[capabilityKey(c)] = true
+	}
+	providers := map[neomega_backbone.Capability][]string{}
+	for _, n := range nodes {
+		for _, c := range n.Provides {
+			providers[capabilityKey(c)] = append(providers[capabilityKey(c)], n.Name)
+		}
+	}
+	sorted := make([]DependencyNode, len(nodes))
+	copy(sorted, nodes)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	depErr := &DependencyError{}
+	// deps[a] 为 a 依赖的组件, dependents[b] 为依赖 b 的组件
+	deps := map[string]map[string]bool{}
+	dependents := map[string][]string{}
+	for _, n := range sorted {
+		deps[n.Name] = map[string]bool{}
+		for _, c := range n.Requires {
+			ps := providers[capabilityKey(c)]
+			if len(ps) == 0 && !available[capabilityKey(c)] && !c.Optional {
+				depErr.Missing = append(depErr.Missing, MissingProvider{Component: n.Name, Capability: c})
+			}
+			for _, p := range ps {
+				if p != n.Name && !deps[n.Name][p] {
+					deps[n.Name][p] = true
+					dependents[p] = append(dependents[p], n.Name)
+				}
+			}
+		}
+	}
+
+	order := make([]string, 0, len(sorted))
+	indegree := map[string]int{}
+	ready := []string{}
+	for _, n := range sorted {
+		indegree[n.Name] = len(deps[n.Name])
+		if indegree[n.Name] == 0 {
+			ready = append(ready, n.Name)
+		}
+	}
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, d := range dependents[name] {
+			indegree[d]--
+			if indegree[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+	if len(order) < len(sorted) {
+		depErr.Cycles = findCycles(sorted, deps, indegree)
+	}
+	if len(depErr.Missing) > 0 || len(depErr.Cycles) > 0 {
+		return nil, depErr
+	}
+	return order, nil
+}
+
+// findCycles 在拓扑排序后剩余(indegree>0)的节点中找出环, 每个节点最多出现在一个环中
+func findCycles(sorted []DependencyNode, deps map[string]map[string]bool, indegree map[string]int) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	cycles := [][]string{}
+	var stack []string
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		next := make([]string, 0, len(deps[name]))
+		for d := range deps[name] {
+			next = append(next, d)
+		}
+		sort.Strings(next)
+		for _, d := range next {
+			if indegree[d] == 0 {
+				continue
+			}
+			switch state[d] {
+			case unvisited:
+				visit(d)
+			case visiting:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == d {
+						cycle := append([]string{}, stack[i:]...)
+						cycles = append(cycles, append(cycle, d))
+						break
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+	}
+	for _, n := range sorted {
+		if indegree[n.Name] > 0 && state[n.Name] == unvisited {
+			visit(n.Name)
+		}
+	}
+	return cycles
+}
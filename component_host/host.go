@@ -0,0 +1,511 @@
+package component_host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+var (
+	ErrNotRunning     = errors.New("component_host: component not running")
+	ErrAlreadyRunning = errors.New("component_host: component already running")
+	// 调用已停止组件注册的 api 时得到的错误
+	ErrComponentStopped = errors.New("component_host: component stopped")
+)
+
+// PanicError 记录组件中发生的 panic, 包括 factory, Init, Inject, BeforeActivate 和 Activate
+type PanicError struct {
+	Component string
+	Value     any
+	Stack     []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("component_host: %v panicked: %v", e.Component, e.Value)
+}
+
+type Options struct {
+	// 停止组件时等待 ActivateWithContext 返回的时间, 超时后不再等待, 直接调用 Dispose
+	StopTimeout time.Duration
+	// 未通过 SetRestartPolicy 单独设置的组件使用该策略
+	DefaultRestartPolicy RestartPolicy
+}
+
+func DefaultOptions() Options {
+	return Options{
+		StopTimeout:          10 * time.Second,
+		DefaultRestartPolicy: DefaultRestartPolicy(),
+	}
+}
+
+type ComponentState struct {
+	Name string
+	// Activate 崩溃且不再重启
+	Crashed bool
+	// Window 内的重启次数
+	Restarts  int
+	LastCrash error
+}
+
+type instance struct {
+	name      string
+	factory   neomega_backbone.DynamicComponentFactory
+	challenge neomega_backbone.ChallengeFn
+	cfg       neomega_backbone.DynamicComponentConfig
+	storage   neomega_backbone.StorageAndPathAccess
+
+	// 以下字段需要持有 Host.mu
+	// 当前运行的组件, 在第一次启动完成前为 nil
+	current *launched
+	// current 提供的能力是否可用: 激活后可用, 直到 current 崩溃或在重启前被 Dispose
+	// Activate 正常返回不影响, 提供者可能在 Inject/BeforeActivate 中就完成了注册
+	providing bool
+	crashed   bool
+	restarts  []time.Time
+	lastCrash error
+}
+
+// launched 是一次(重新)启动得到的组件, 创建后不再修改, 除了 Dispose 的结果
+type launched struct {
+	component neomega_backbone.DynamicComponent
+	omega     *trackedOmega
+	provides  []neomega_backbone.Capability
+	ctx       context.Context
+	cancel    context.CancelFunc
+	// Activate/ActivateWithContext 返回后关闭
+	done chan struct{}
+
+	disposeOnce sync.Once
+}
+
+// Host 负责 DynamicComponent 的启动, 停止, 重载, 以及崩溃后的重启
+// 每个组件拿到的 ExtendOmega 都经过包装, 组件停止时其注册的菜单项, api 和监听会被注销, 见 trackedOmega
+// 组件的 factory, Init, Inject, BeforeActivate 和 Dispose 都在不持有 Host 内部锁的情况下调用, 因此组件可以在其中调用 Host
+type Host struct {
+	frame neomega_backbone.ExtendOmega
+	opts  Options
+	hub   *listenerHub
+
+	mu sync.Mutex
+	// 正在启动的组件也在其中, 以防同名组件被重复启动
+	instances map[string]*instance
+	policies  map[string]RestartPolicy
+	// 由框架等组件以外的来源提供的能力
+	external []neomega_backbone.Capability
+	// 可用的能力增加时被关闭并替换, 用于唤醒等待依赖的重启
+	capsChanged chan struct{}
+}
+
+func NewHost(frame neomega_backbone.ExtendOmega, opts Options) *Host {
+	return &Host{
+		frame:       frame,
+		opts:        opts,
+		hub:         newListenerHub(frame),
+		instances:   map[string]*instance{},
+		policies:    map[string]RestartPolicy{},
+		capsChanged: make(chan struct{}),
+	}
+}
+
+// SetRestartPolicy 设置组件 name 的重启策略, 对正在运行的组件在其下一次结束时生效
+func (h *Host) SetRestartPolicy(name string, policy RestartPolicy) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.policies[name] = policy
+}
+
+func (h *Host) restartPolicy(name string) RestartPolicy {
+	if policy, ok := h.policies[name]; ok {
+		return policy
+	}
+	return h.opts.DefaultRestartPolicy
+}
+
+// componentOut 返回写入 ${log}/<name>.log 的输出
+func (h *Host) componentOut(name string) *neomega_backbone.MultiOutDst {
+	return h.frame.ForkOut(name, h.frame.GetLoggerPath(name+".log"))
+}
+
+// Start 通过 factory 创建名为 name 的组件, 依次调用 Init, Inject, BeforeActivate, 然后在新的 goroutine 中 Activate
+func (h *Host) Start(name string, factory neomega_backbone.DynamicComponentFactory, challenge neomega_backbone.ChallengeFn, cfg neomega_backbone.DynamicComponentConfig, storage neomega_backbone.StorageAndPathAccess) error {
+	h.mu.Lock()
+	if _, ok := h.instances[name]; ok {
+		h.mu.Unlock()
+		return fmt.Errorf("%w: %v", ErrAlreadyRunning, name)
+	}
+	inst := &instance{name: name, factory: factory, challenge: challenge, cfg: cfg, storage: storage}
+	h.instances[name] = inst
+	h.mu.Unlock()
+	return h.launch(inst)
+}
+
+// launch 创建并激活已经放入 instances 的 inst, 调用者不能持有锁
+// 失败时 inst 被移出 instances, 启动期间 inst 被 Stop/Reload 替换时新创建的组件被丢弃
+func (h *Host) launch(inst *instance) error {
+	l, err := h.prepare(inst)
+	h.mu.Lock()
+	stillCurrent := h.instances[inst.name] == inst
+	if err != nil {
+		if stillCurrent {
+			delete(h.instances, inst.name)
+		}
+		h.mu.Unlock()
+		return err
+	}
+	if !stillCurrent {
+		h.mu.Unlock()
+		return h.abandon(inst, l)
+	}
+	h.activate(inst, l)
+	h.mu.Unlock()
+	return nil
+}
+
+// prepare 创建组件, 检查其依赖并调用 BeforeActivate, 调用者不能持有锁
+func (h *Host) prepare(inst *instance) (*launched, error) {
+	component, omega, err := h.create(inst)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.checkRequires(inst.name, component); err != nil {
+		omega.release()
+		return nil, err
+	}
+	if err := beforeActivate(inst.name, component, omega); err != nil {
+		return nil, err
+	}
+	return newLaunched(component, omega), nil
+}
+
+// checkRequires 检查单独(重新)启动的组件依赖的能力当前是否可用, 不可用时返回 *DependencyError
+// StartAll 中的组件由 ResolveOrder 统一排序, 不经过这里
+func (h *Host) checkRequires(name string, component neomega_backbone.DynamicComponent) error {
+	dependent, ok := component.(neomega_backbone.HasDependencies)
+	if !ok {
+		return nil
+	}
+	node := DependencyNode{Name: name, Provides: dependent.Provides(), Requires: dependent.Requires()}
+	h.mu.Lock()
+	available := h.availableCapabilities()
+	h.mu.Unlock()
+	_, err := ResolveOrder([]DependencyNode{node}, available)
+	return err
+}
+
+func newLaunched(component neomega_backbone.DynamicComponent, omega *trackedOmega) *launched {
+	l := &launched{component: component, omega: omega, done: make(chan struct{})}
+	if dependent, ok := component.(neomega_backbone.HasDependencies); ok {
+		l.provides = dependent.Provides()
+	}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	return l
+}
+
+// abandon 丢弃在启动期间被 Stop/Reload 的组件, 它通过了 BeforeActivate 但从未 Activate
+// 被 Reload 替换时临时目录由新实例继续使用, 不做清理
+func (h *Host) abandon(inst *instance, l *launched) error {
+	l.cancel()
+	close(l.done)
+	err := h.dispose(inst, l)
+	h.mu.Lock()
+	_, replaced := h.instances[inst.name]
+	h.mu.Unlock()
+	if !replaced {
+		err = errors.Join(err, h.releaseTempDirs(inst))
+	}
+	return errors.Join(fmt.Errorf("%w: %v stopped while starting", ErrNotRunning, inst.name), err)
+}
+
+// recoverAsError 将 panic 转换为 PanicError 存入 err, 需要直接被 defer
+func recoverAsError(name string, err *error, cleanup func()) {
+	if r := recover(); r != nil {
+		if cleanup != nil {
+			cleanup()
+		}
+		*err = &PanicError{Component: name, Value: r, Stack: debug.Stack()}
+	}
+}
+
+// create 通过 factory 创建组件并调用 Init, Inject, 其中的 panic 被转换为 PanicError
+func (h *Host) create(inst *instance) (component neomega_backbone.DynamicComponent, omega *trackedOmega, err error) {
+	defer recoverAsError(inst.name, &err, func() {
+		if omega != nil {
+			omega.release()
+		}
+		component, omega = nil, nil
+	})
+	component = inst.factory(inst.name, inst.challenge)
+	if component == nil {
+		return nil, nil, fmt.Errorf("component_host: factory of %v returned nil", inst.name)
+	}
+	omega = newTrackedOmega(inst.name, h.frame, h.hub)
+	component.Init(inst.cfg, inst.storage)
+	component.Inject(omega)
+	return component, omega, nil
+}
+
+// beforeActivate 调用组件的 BeforeActivate, 失败或 panic 时注销组件已经注册的内容
+func beforeActivate(name string, component neomega_backbone.DynamicComponent, omega *trackedOmega) (err error) {
+	defer recoverAsError(name, &err, omega.release)
+	if err := component.BeforeActivate(); err != nil {
+		omega.release()
+		return fmt.Errorf("component_host: %v BeforeActivate: %w", name, err)
+	}
+	return nil
+}
+
+// activate 使 l 成为 inst 当前运行的组件, 并在新的 goroutine 中运行其 Activate, 调用者需要持有锁
+func (h *Host) activate(inst *instance, l *launched) {
+	inst.current, inst.providing, inst.crashed = l, true, false
+	h.notifyCapsChanged()
+	go h.run(inst, l)
+}
+
+// notifyCapsChanged 唤醒等待依赖的重启, 调用者需要持有锁
+func (h *Host) notifyCapsChanged() {
+	close(h.capsChanged)
+	h.capsChanged = make(chan struct{})
+}
+
+// runActivate 在 recover 下运行组件的 Activate, panic 被转换为 PanicError
+func runActivate(ctx context.Context, name string, component neomega_backbone.DynamicComponent) (err error) {
+	defer recoverAsError(name, &err, nil)
+	if stoppable, ok := component.(neomega_backbone.StoppableDynamicComponent); ok {
+		stoppable.ActivateWithContext(ctx)
+	} else {
+		component.Activate()
+	}
+	return nil
+}
+
+func (h *Host) run(inst *instance, l *launched) {
+	err := runActivate(l.ctx, inst.name, l.component)
+	close(l.done)
+	if l.ctx.Err() != nil {
+		// 被 Stop/Reload 停止
+		return
+	}
+	if err != nil {
+		h.mu.Lock()
+		if inst.current == l {
+			inst.providing = false
+		}
+		h.mu.Unlock()
+		out := h.componentOut(inst.name)
+		if panicErr, ok := err.(*PanicError); ok {
+			out.Error.Printfln("组件 %v 崩溃: %v\n%s", inst.name, panicErr.Value, panicErr.Stack)
+		} else {
+			out.Error.Printfln("组件 %v 崩溃: %v", inst.name, err)
+		}
+	}
+	h.supervise(inst, l, err)
+}
+
+// supervise 根据重启策略在组件 l 结束后重新创建并启动它, 直到启动成功, 超出重启次数限制或组件被停止
+func (h *Host) supervise(inst *instance, l *launched, err error) {
+	// exitErr 为最近一次结束或重启失败的原因, 等待依赖时不会被覆盖
+	exitErr, waiting := err, false
+	for {
+		h.mu.Lock()
+		if h.instances[inst.name] != inst {
+			h.mu.Unlock()
+			return
+		}
+		if err != nil {
+			inst.lastCrash = err
+		}
+		policy := h.restartPolicy(inst.name)
+		if !policy.shouldRestart(err != nil) {
+			inst.crashed = err != nil
+			h.mu.Unlock()
+			return
+		}
+		delay, recent, ok := policy.nextDelay(inst.restarts, time.Now())
+		inst.restarts = recent
+		if !ok {
+			inst.crashed = true
+			h.mu.Unlock()
+			h.componentOut(inst.name).Error.Printfln("组件 %v 在 %v 内已重启 %v 次, 不再重启", inst.name, policy.Window, len(recent))
+			return
+		}
+		h.mu.Unlock()
+
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		h.mu.Lock()
+		if h.instances[inst.name] != inst {
+			h.mu.Unlock()
+			return
+		}
+		inst.restarts = append(inst.restarts, time.Now())
+		inst.providing = false
+		h.mu.Unlock()
+		if disposeErr := errors.Join(h.dispose(inst, l), h.releaseTempDirs(inst)); disposeErr != nil {
+			h.componentOut(inst.name).Warning.Printfln("%v", disposeErr)
+		}
+		var next *launched
+		next, err = h.prepare(inst)
+		h.mu.Lock()
+		if h.instances[inst.name] != inst {
+			// 重启期间被 Stop/Reload
+			h.mu.Unlock()
+			if err == nil {
+				h.abandon(inst, next)
+			}
+			return
+		}
+		if err == nil {
+			h.activate(inst, next)
+			h.mu.Unlock()
+			l.cancel()
+			h.componentOut(inst.name).Warning.Printfln("组件 %v 已重启", inst.name)
+			return
+		}
+		if errors.Is(err, ErrUnresolvedDependency) {
+			// 依赖的能力暂不可用(e.g. 提供者也在等待重启), 不计入重启次数, 等到有新的能力可用时再重试
+			inst.restarts = inst.restarts[:len(inst.restarts)-1]
+			changed := h.capsChanged
+			h.mu.Unlock()
+			if !waiting {
+				waiting = true
+				h.componentOut(inst.name).Warning.Printfln("组件 %v 等待依赖恢复后重启: %v", inst.name, err)
+			}
+			select {
+			case <-l.ctx.Done():
+				return
+			case <-changed:
+			}
+			err = exitErr
+			continue
+		}
+		h.mu.Unlock()
+		exitErr, waiting = err, false
+		// 重启失败(包括 panic)计入重启次数, 由下一轮循环按重启策略决定是否继续
+		if panicErr, ok := err.(*PanicError); ok {
+			h.componentOut(inst.name).Error.Printfln("组件 %v 重启失败: %v\n%s", inst.name, panicErr.Value, panicErr.Stack)
+		} else {
+			h.componentOut(inst.name).Error.Printfln("组件 %v 重启失败: %v", inst.name, err)
+		}
+	}
+}
+
+// dispose 注销组件 l 注册的内容并调用 Dispose, 只有第一次调用生效, 之后的调用直接返回 nil
+func (h *Host) dispose(inst *instance, l *launched) (err error) {
+	l.disposeOnce.Do(func() {
+		l.omega.release()
+		if stoppable, ok := l.component.(neomega_backbone.StoppableDynamicComponent); ok {
+			if err = stoppable.Dispose(); err != nil {
+				err = fmt.Errorf("component_host: stop %v: %w", inst.name, err)
+			}
+		}
+	})
+	return err
+}
+
+// releaseTempDirs 清理组件在 storage 中创建的临时目录
+// 临时目录属于 storage 而非某次启动, 因此只在没有新实例会使用该 storage 时调用
+func (h *Host) releaseTempDirs(inst *instance) error {
+	if releaser, ok := inst.storage.(interface{ ReleaseTempDirs() error }); ok {
+		if err := releaser.ReleaseTempDirs(); err != nil {
+			return fmt.Errorf("component_host: stop %v: %w", inst.name, err)
+		}
+	}
+	return nil
+}
+
+// shutdown 取消组件 l 的 ctx, 等待其返回, 然后注销其注册的内容, 调用 Dispose 并清理临时目录, 调用者不能持有锁
+// inst 必须已经从 instances 中移除, 此后 supervise 不会再启动它; l 为 nil (inst 仍在启动)时由启动方丢弃新组件
+func (h *Host) shutdown(inst *instance, l *launched) error {
+	if l == nil {
+		return h.releaseTempDirs(inst)
+	}
+	l.cancel()
+	select {
+	case <-l.done:
+	case <-time.After(h.opts.StopTimeout):
+		h.frame.Out().Warning.Printfln("组件 %v 在 %v 内未能停止, 将不再等待", inst.name, h.opts.StopTimeout)
+	}
+	return errors.Join(h.dispose(inst, l), h.releaseTempDirs(inst))
+}
+
+func (h *Host) Stop(name string) error {
+	h.mu.Lock()
+	inst, ok := h.instances[name]
+	var current *launched
+	if ok {
+		current = inst.current
+		delete(h.instances, name)
+	}
+	h.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrNotRunning, name)
+	}
+	return h.shutdown(inst, current)
+}
+
+// Reload 停止组件, 然后通过其 factory 以 cfg 重新创建, cfg 为 nil 时沿用原来的配置
+// 新实例启动失败时组件保持停止状态
+func (h *Host) Reload(name string, cfg neomega_backbone.DynamicComponentConfig) error {
+	h.mu.Lock()
+	inst, ok := h.instances[name]
+	if !ok {
+		h.mu.Unlock()
+		return fmt.Errorf("%w: %v", ErrNotRunning, name)
+	}
+	current := inst.current
+	next := &instance{name: name, factory: inst.factory, challenge: inst.challenge, cfg: inst.cfg, storage: inst.storage}
+	if cfg != nil {
+		next.cfg = cfg
+	}
+	// next 占据 name, 重载期间 Start 同名组件会失败, supervise 也不会再重启 inst
+	h.instances[name] = next
+	h.mu.Unlock()
+	stopErr := h.shutdown(inst, current)
+	if err := h.launch(next); err != nil {
+		return errors.Join(stopErr, err)
+	}
+	return stopErr
+}
+
+// Running 返回所有由 Host 管理(未被 Stop)的组件的名称, 包括已崩溃的组件
+func (h *Host) Running() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	names := make([]string, 0, len(h.instances))
+	for name := range h.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (h *Host) States() []ComponentState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	states := make([]ComponentState, 0, len(h.instances))
+	for name, inst := range h.instances {
+		states = append(states, ComponentState{Name: name, Crashed: inst.crashed, Restarts: len(inst.restarts), LastCrash: inst.lastCrash})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+func (h *Host) StopAll() error {
+	var errs []error
+	for _, name := range h.Running() {
+		if err := h.Stop(name); err != nil && !errors.Is(err, ErrNotRunning) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,51 @@
+package component_host
+
+import (
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+// AddHostBackendMenu 注册终端菜单项 components, 用于查看, 停止和重载组件
+func AddHostBackendMenu(h *Host, backend neomega_backbone.BackendIO) {
+	out := backend.Out()
+	backend.AddBackendMenuEntry(&neomega_backbone.BackendMenuEntry{
+		MenuEntry: neomega_backbone.MenuEntry{
+			Triggers:     []string{"components", "组件"},
+			ArgumentHint: "[reload|stop] [组件名]",
+			Usage:        "列出组件及其状态, 或以当前配置重载/停止指定组件",
+		},
+		OnTrigCallBack: func(cmds []string) {
+			if len(cmds) == 0 {
+				for _, state := range h.States() {
+					switch {
+					case state.Crashed:
+						out.Error.Printfln("%v 已崩溃: %v", state.Name, state.LastCrash)
+					case state.Restarts > 0:
+						out.Warning.Printfln("%v 运行中, 近期重启 %v 次", state.Name, state.Restarts)
+					default:
+						out.Info.Printfln("%v 运行中", state.Name)
+					}
+				}
+				return
+			}
+			if len(cmds) < 2 {
+				out.Warning.Printfln("需要指定组件名")
+				return
+			}
+			var err error
+			switch cmds[0] {
+			case "reload", "重载":
+				err = h.Reload(cmds[1], nil)
+			case "stop", "停止":
+				err = h.Stop(cmds[1])
+			default:
+				out.Warning.Printfln("未知操作 %v", cmds[0])
+				return
+			}
+			if err != nil {
+				out.Error.Printfln("%v", err)
+				return
+			}
+			out.Success.Printfln("%v %v 完成", cmds[0], cmds[1])
+		},
+	})
+}
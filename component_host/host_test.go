@@ -0,0 +1,162 @@
+package component_host
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+type discardPrinter struct{}
+
+func (discardPrinter) Write(string)                    {}
+func (discardPrinter) Print(...interface{})            {}
+func (discardPrinter) Printf(string, ...interface{})   {}
+func (discardPrinter) Println(...interface{})          {}
+func (discardPrinter) Printfln(string, ...interface{}) {}
+
+// testFrame 只实现 Host 在不注册任何内容的组件上会用到的方法
+type testFrame struct {
+	neomega_backbone.ExtendOmega
+}
+
+func (testFrame) Out() *neomega_backbone.MultiOutDst {
+	p := discardPrinter{}
+	return &neomega_backbone.MultiOutDst{Printer: p, Terminal: p, Log: p, TerminalAndLog: p, Debug: p, Info: p, Success: p, Warning: p, Error: p}
+}
+
+func (f testFrame) ForkOut(string, string) *neomega_backbone.MultiOutDst { return f.Out() }
+
+func (testFrame) GetLoggerPath(topic string) string { return topic }
+
+// depComponent 是只实现 DynamicComponent 的组件, activate 为 nil 时 Activate 立即返回
+type depComponent struct {
+	provides, requires []neomega_backbone.Capability
+	activate           func()
+}
+
+func (c *depComponent) Init(neomega_backbone.DynamicComponentConfig, neomega_backbone.StorageAndPathAccess) {
+}
+func (c *depComponent) Inject(neomega_backbone.ExtendOmega)     {}
+func (c *depComponent) BeforeActivate() error                   { return nil }
+func (c *depComponent) Provides() []neomega_backbone.Capability { return c.provides }
+func (c *depComponent) Requires() []neomega_backbone.Capability { return c.requires }
+
+func (c *depComponent) Activate() {
+	if c.activate != nil {
+		c.activate()
+	}
+}
+
+var testCapability = neomega_backbone.Capability{Kind: neomega_backbone.CapabilityService, Name: "x"}
+
+func testHost() *Host {
+	return NewHost(testFrame{}, Options{StopTimeout: time.Second, DefaultRestartPolicy: RestartPolicy{
+		Mode: RestartOnFailure, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxRestarts: 5, Window: time.Minute,
+	}})
+}
+
+func noopProvider(string, neomega_backbone.ChallengeFn) neomega_backbone.DynamicComponent {
+	return &depComponent{provides: []neomega_backbone.Capability{testCapability}}
+}
+
+// crashOnceDependent 返回依赖 testCapability 的组件的 factory, 第一次 Activate 时 panic
+func crashOnceDependent(created, activated *atomic.Int32) neomega_backbone.DynamicComponentFactory {
+	return func(string, neomega_backbone.ChallengeFn) neomega_backbone.DynamicComponent {
+		created.Add(1)
+		return &depComponent{requires: []neomega_backbone.Capability{testCapability}, activate: func() {
+			if activated.Add(1) == 1 {
+				panic("crash")
+			}
+		}}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Activate 立即返回的提供者在其之后仍然提供能力, 依赖它的组件可以单独启动, 崩溃后也能重启
+func TestNoopProviderSatisfiesDependents(t *testing.T) {
+	h := testHost()
+	defer h.StopAll()
+	if err := h.Start("p", noopProvider, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 等待 p 的 Activate 返回
+	time.Sleep(10 * time.Millisecond)
+
+	var created, activated atomic.Int32
+	if err := h.Start("d", crashOnceDependent(&created, &activated), nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return activated.Load() == 2 })
+	time.Sleep(10 * time.Millisecond)
+	if n := created.Load(); n != 2 {
+		t.Fatalf("dependent created %v times, expect 2", n)
+	}
+	for _, state := range h.States() {
+		if state.Crashed {
+			t.Fatalf("%v crashed: %v", state.Name, state.LastCrash)
+		}
+	}
+	if err := h.Reload("d", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 依赖不可用时重启等待新的能力, 而不是反复创建组件
+func TestRestartWaitsForCapability(t *testing.T) {
+	h := testHost()
+	defer h.StopAll()
+	if err := h.Start("d", crashOnceDependent(new(atomic.Int32), new(atomic.Int32)), nil, nil, nil); !errors.Is(err, ErrUnresolvedDependency) {
+		t.Fatalf("expect ErrUnresolvedDependency, got %v", err)
+	}
+
+	h.SetRestartPolicy("p", RestartPolicy{Mode: RestartNever})
+	crashP, crashD := make(chan struct{}), make(chan struct{})
+	if err := h.Start("p", func(string, neomega_backbone.ChallengeFn) neomega_backbone.DynamicComponent {
+		return &depComponent{provides: []neomega_backbone.Capability{testCapability}, activate: func() {
+			<-crashP
+			panic("crash")
+		}}
+	}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var created, activated atomic.Int32
+	if err := h.Start("d", func(string, neomega_backbone.ChallengeFn) neomega_backbone.DynamicComponent {
+		created.Add(1)
+		return &depComponent{requires: []neomega_backbone.Capability{testCapability}, activate: func() {
+			if activated.Add(1) == 1 {
+				<-crashD
+				panic("crash")
+			}
+		}}
+	}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	close(crashP)
+	waitFor(t, func() bool { return h.States()[1].Crashed })
+	close(crashD)
+	waitFor(t, func() bool { return created.Load() == 2 })
+	time.Sleep(20 * time.Millisecond)
+	if n := created.Load(); n != 2 {
+		t.Fatalf("dependent created %v times while waiting, expect 2", n)
+	}
+
+	h.AddExternalCapabilities(testCapability)
+	waitFor(t, func() bool { return activated.Load() == 2 })
+	if state := h.States()[0]; state.Crashed || state.Restarts != 1 {
+		t.Fatalf("unexpected state %+v", state)
+	}
+}
@@ -0,0 +1,276 @@
+package component_host
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+	"github.com/OmineDev/neomega-core/utils/async_wrapper"
+	"github.com/OmineDev/qq-bot-helper/packet"
+)
+
+// 底层不支持移除监听, 若每个组件实例都直接向底层注册, 每次重载都会多留下一个监听
+// 因此 listenerHub 对每个 topic (以及 api) 只向底层注册一次, 再分发给当前注册了它的组件, 组件停止时移除其回调
+type listener[T any] struct {
+	owner        *trackedOmega
+	fn           func(T)
+	newGoroutine bool
+}
+
+type fanout[T any] struct {
+	listeners []listener[T]
+}
+
+func (f *fanout[T]) remove(owner *trackedOmega) {
+	kept := make([]listener[T], 0, len(f.listeners))
+	for _, l := range f.listeners {
+		if l.owner != owner {
+			kept = append(kept, l)
+		}
+	}
+	f.listeners = kept
+}
+
+type cqPacket struct {
+	pk   packet.CQPacket
+	data []byte
+}
+
+type cqMessage struct {
+	source, name, message string
+}
+
+// apiEntry 是向底层注册过一次的 api, 请求被转发给最后注册它的组件
+type apiEntry[A, R any] struct {
+	owner   *trackedOmega
+	handler func(A, func(R))
+}
+
+type listenerHub struct {
+	frame neomega_backbone.ExtendOmega
+
+	mu            sync.RWMutex
+	soft          map[string]*fanout[neomega_backbone.CanGetData]
+	inProcess     map[string]*fanout[any]
+	packets       *fanout[cqPacket]
+	messages      *fanout[cqMessage]
+	softAPIs      map[string]*apiEntry[neomega_backbone.CanGetData, []byte]
+	inProcessAPIs map[string]*apiEntry[any, any]
+}
+
+func newListenerHub(frame neomega_backbone.ExtendOmega) *listenerHub {
+	return &listenerHub{
+		frame:         frame,
+		soft:          map[string]*fanout[neomega_backbone.CanGetData]{},
+		inProcess:     map[string]*fanout[any]{},
+		softAPIs:      map[string]*apiEntry[neomega_backbone.CanGetData, []byte]{},
+		inProcessAPIs: map[string]*apiEntry[any, any]{},
+	}
+}
+
+func emit[T any](mu *sync.RWMutex, f *fanout[T], v T) {
+	mu.RLock()
+	listeners := append([]listener[T]{}, f.listeners...)
+	mu.RUnlock()
+	for _, l := range listeners {
+		if !l.owner.alive.Load() {
+			continue
+		}
+		if l.newGoroutine {
+			go l.fn(v)
+		} else {
+			l.fn(v)
+		}
+	}
+}
+
+// listen 将 l 加入 topics[topic], topic 第一次出现时返回 true, 此时调用者需要向底层注册
+// owner 已经停止时不加入, 与 release 共用锁, 因此 release 之后不会再留下 owner 的回调
+func listen[T any](h *listenerHub, topics map[string]*fanout[T], topic string, l listener[T]) (f *fanout[T], first bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !l.owner.alive.Load() {
+		return nil, false
+	}
+	f, ok := topics[topic]
+	if !ok {
+		f = &fanout[T]{}
+		topics[topic] = f
+	}
+	f.listeners = append(f.listeners, l)
+	return f, !ok
+}
+
+func (h *listenerHub) softListen(owner *trackedOmega, topic string, fn func(neomega_backbone.CanGetData)) {
+	if f, first := listen(h, h.soft, topic, listener[neomega_backbone.CanGetData]{owner: owner, fn: fn}); first {
+		h.frame.SoftListen(topic, func(data neomega_backbone.CanGetData) { emit(&h.mu, f, data) })
+	}
+}
+
+// inProcessListen 向底层注册时总是使用 newGoroutine=false, 需要新 goroutine 的回调在分发时各自启动
+func (h *listenerHub) inProcessListen(owner *trackedOmega, topic string, fn func(any), newGoroutine bool) {
+	if f, first := listen(h, h.inProcess, topic, listener[any]{owner: owner, fn: fn, newGoroutine: newGoroutine}); first {
+		h.frame.InProcessListen(topic, func(msg any) { emit(&h.mu, f, msg) }, false)
+	}
+}
+
+func (h *listenerHub) onPacket(owner *trackedOmega, cb func(pk packet.CQPacket, data []byte)) {
+	h.mu.Lock()
+	if !owner.alive.Load() {
+		h.mu.Unlock()
+		return
+	}
+	first := h.packets == nil
+	if first {
+		h.packets = &fanout[cqPacket]{}
+	}
+	f := h.packets
+	f.listeners = append(f.listeners, listener[cqPacket]{owner: owner, fn: func(p cqPacket) { cb(p.pk, p.data) }})
+	h.mu.Unlock()
+	if first {
+		h.frame.RegisterPacketNoBlockCB(func(pk packet.CQPacket, data []byte) { emit(&h.mu, f, cqPacket{pk, data}) })
+	}
+}
+
+func (h *listenerHub) onDefaultMessage(owner *trackedOmega, cb neomega_backbone.DefaultCQMessageCb) {
+	h.mu.Lock()
+	if !owner.alive.Load() {
+		h.mu.Unlock()
+		return
+	}
+	first := h.messages == nil
+	if first {
+		h.messages = &fanout[cqMessage]{}
+	}
+	f := h.messages
+	f.listeners = append(f.listeners, listener[cqMessage]{owner: owner, fn: func(m cqMessage) { cb(m.source, m.name, m.message) }})
+	h.mu.Unlock()
+	if first {
+		h.frame.OnDefaultMessage(func(source, name, message string) { emit(&h.mu, f, cqMessage{source, name, message}) })
+	}
+}
+
+// apiHandle 是交给组件的 AsyncAPISetHandler, 只有 owner 仍然是 api 的注册者时 SetHandler 才生效
+type apiHandle[A, R any] struct {
+	mu    *sync.RWMutex
+	entry *apiEntry[A, R]
+	owner *trackedOmega
+}
+
+func (a *apiHandle[A, R]) SetHandler(fn func(A, func(R))) {
+	if a.entry == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.entry.owner == a.owner {
+		a.entry.handler = fn
+	}
+}
+
+// gatedAPI 直接向底层注册, 用于同名 api 已被另一个运行中的组件注册的情况, 是否允许重复注册由底层决定
+type gatedAPI[A, R any] struct {
+	inner   async_wrapper.AsyncAPISetHandler[A, R]
+	owner   *trackedOmega
+	stopped func(name string) R
+}
+
+func (g *gatedAPI[A, R]) SetHandler(fn func(A, func(R))) {
+	g.inner.SetHandler(func(args A, respond func(R)) {
+		if g.owner.alive.Load() {
+			fn(args, respond)
+		} else {
+			respond(g.stopped(g.owner.name))
+		}
+	})
+}
+
+// regAPI 在 entries 中登记 owner 注册的 api, 底层注册只在第一次发生, 之后(e.g. 重载后的新实例)只替换 handler
+// 注册者停止后, 请求得到 stopped 返回的错误响应
+func regAPI[A, R any](h *listenerHub, entries map[string]*apiEntry[A, R], owner *trackedOmega, name string,
+	reg func(string) async_wrapper.AsyncAPISetHandler[A, R], stopped func(name string) R,
+) async_wrapper.AsyncAPISetHandler[A, R] {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !owner.alive.Load() {
+		return &apiHandle[A, R]{}
+	}
+	e, ok := entries[name]
+	if ok && e.owner != owner && e.owner.alive.Load() {
+		return &gatedAPI[A, R]{inner: reg(name), owner: owner, stopped: stopped}
+	}
+	if !ok {
+		e = &apiEntry[A, R]{}
+		entries[name] = e
+		reg(name).SetHandler(func(args A, respond func(R)) {
+			h.mu.RLock()
+			current, fn := e.owner, e.handler
+			h.mu.RUnlock()
+			if fn == nil || !current.alive.Load() {
+				respond(stopped(current.name))
+				return
+			}
+			fn(args, respond)
+		})
+	}
+	e.owner, e.handler = owner, nil
+	return &apiHandle[A, R]{mu: &h.mu, entry: e, owner: owner}
+}
+
+func softAPIStopped(name string) []byte {
+	resp, _ := json.Marshal(map[string]string{"error": fmt.Sprintf("%v: %v", ErrComponentStopped, name)})
+	return resp
+}
+
+func inProcessAPIStopped(name string) any {
+	return fmt.Errorf("%w: %v", ErrComponentStopped, name)
+}
+
+func (h *listenerHub) regSoftAPI(owner *trackedOmega, cmd string) async_wrapper.AsyncAPISetHandler[neomega_backbone.CanGetData, []byte] {
+	return regAPI(h, h.softAPIs, owner, cmd, h.frame.RegSoftAPI, softAPIStopped)
+}
+
+func (h *listenerHub) regInProcessAPI(owner *trackedOmega, apiName string) async_wrapper.AsyncAPISetHandler[any, any] {
+	return regAPI(h, h.inProcessAPIs, owner, apiName, h.frame.RegInProcessAPI, inProcessAPIStopped)
+}
+
+// release 移除 owner 的所有回调, owner.alive 需要已经被置为 false
+// 底层支持时注销 owner 注册的 api, 否则 api 保留在底层, 请求得到错误响应, 直到有组件重新注册它
+func (h *listenerHub) release(owner *trackedOmega) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, f := range h.soft {
+		f.remove(owner)
+	}
+	for _, f := range h.inProcess {
+		f.remove(owner)
+	}
+	if h.packets != nil {
+		h.packets.remove(owner)
+	}
+	if h.messages != nil {
+		h.messages.remove(owner)
+	}
+	remover, canRemove := h.frame.(neomega_backbone.SoftAPIRemover)
+	for cmd, e := range h.softAPIs {
+		if e.owner != owner {
+			continue
+		}
+		e.handler = nil
+		if canRemove {
+			delete(h.softAPIs, cmd)
+			remover.UnregSoftAPI(cmd)
+		}
+	}
+	for apiName, e := range h.inProcessAPIs {
+		if e.owner != owner {
+			continue
+		}
+		e.handler = nil
+		if canRemove {
+			delete(h.inProcessAPIs, apiName)
+			remover.UnregInProcessAPI(apiName)
+		}
+	}
+}
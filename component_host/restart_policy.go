@@ -0,0 +1,84 @@
+package component_host
+
+import (
+	"fmt"
+	"time"
+)
+
+type RestartMode int
+
+const (
+	// 崩溃后不再重启
+	RestartNever RestartMode = iota
+	// 仅在 Activate panic 或重启时 BeforeActivate 失败后重启
+	RestartOnFailure
+	// Activate 返回(包括正常返回)后总是重启, 只适用于 Activate 会一直阻塞到组件停止的组件
+	RestartAlways
+)
+
+func (m RestartMode) String() string {
+	switch m {
+	case RestartNever:
+		return "never"
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartAlways:
+		return "always"
+	}
+	return fmt.Sprintf("RestartMode(%d)", int(m))
+}
+
+type RestartPolicy struct {
+	Mode RestartMode
+	// 第 n 次重启前等待 InitialBackoff*2^(n-1), 最多等待 MaxBackoff, n 为 Window 内的重启次数
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Window 内最多重启 MaxRestarts 次, 超过后组件保持停止, MaxRestarts<=0 表示不限制
+	MaxRestarts int
+	Window      time.Duration
+}
+
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		Mode:           RestartOnFailure,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		MaxRestarts:    5,
+		Window:         10 * time.Minute,
+	}
+}
+
+// shouldRestart 判断组件结束后是否需要重启
+func (p RestartPolicy) shouldRestart(failed bool) bool {
+	switch p.Mode {
+	case RestartOnFailure:
+		return failed
+	case RestartAlways:
+		return true
+	}
+	return false
+}
+
+// nextDelay 根据 Window 内的重启记录 history 计算下一次重启前的等待时间, 超出次数限制时 ok 为 false
+// 返回的 history 去除了 Window 之外的记录
+func (p RestartPolicy) nextDelay(history []time.Time, now time.Time) (delay time.Duration, recent []time.Time, ok bool) {
+	for _, t := range history {
+		if p.Window <= 0 || now.Sub(t) < p.Window {
+			recent = append(recent, t)
+		}
+	}
+	if p.MaxRestarts > 0 && len(recent) >= p.MaxRestarts {
+		return 0, recent, false
+	}
+	delay = p.InitialBackoff
+	for i := 0; i < len(recent) && delay > 0; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay, recent, true
+}
@@ -0,0 +1,169 @@
+package component_host
+
+import (
+	"errors"
+	"fmt"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+type ComponentSpec struct {
+	Name      string
+	Factory   neomega_backbone.DynamicComponentFactory
+	Challenge neomega_backbone.ChallengeFn
+	Config    neomega_backbone.DynamicComponentConfig
+	Storage   neomega_backbone.StorageAndPathAccess
+}
+
+// AddExternalCapabilities 声明由框架(而非 Host 管理的组件)提供的能力, 依赖它们的组件不会因此报告缺少提供者
+func (h *Host) AddExternalCapabilities(caps ...neomega_backbone.Capability) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.external = append(h.external, caps...)
+	h.notifyCapsChanged()
+}
+
+// StartAll 创建 specs 中的所有组件并调用 Init, Inject, 然后按照 HasDependencies 声明的依赖关系排序,
+// 依次调用 BeforeActivate, 再依次启动 Activate
+// 依赖无法满足(缺少提供者或循环依赖)时不启动任何组件, 返回 *DependencyError
+// 某个组件 BeforeActivate 失败时, 该组件和(直接或间接)依赖它的组件不会启动, 其余组件照常启动, 所有错误被合并返回
+func (h *Host) StartAll(specs []ComponentSpec) error {
+	type created struct {
+		inst      *instance
+		component neomega_backbone.DynamicComponent
+		omega     *trackedOmega
+		node      DependencyNode
+	}
+	// 先在锁内占据所有名称, 之后的 factory, Init, Inject 和 BeforeActivate 都在锁外调用
+	h.mu.Lock()
+	insts := make([]*instance, 0, len(specs))
+	reserved := map[string]*instance{}
+	unreserve := func() {
+		for name, inst := range reserved {
+			if h.instances[name] == inst {
+				delete(h.instances, name)
+			}
+		}
+	}
+	for _, spec := range specs {
+		if _, ok := reserved[spec.Name]; ok {
+			unreserve()
+			h.mu.Unlock()
+			return fmt.Errorf("component_host: duplicate component %v", spec.Name)
+		}
+		if _, ok := h.instances[spec.Name]; ok {
+			unreserve()
+			h.mu.Unlock()
+			return fmt.Errorf("%w: %v", ErrAlreadyRunning, spec.Name)
+		}
+		inst := &instance{name: spec.Name, factory: spec.Factory, challenge: spec.Challenge, cfg: spec.Config, storage: spec.Storage}
+		h.instances[spec.Name] = inst
+		reserved[spec.Name] = inst
+		insts = append(insts, inst)
+	}
+	external := h.availableCapabilities()
+	h.mu.Unlock()
+
+	byName := map[string]*created{}
+	abort := func(err error) error {
+		for _, c := range byName {
+			c.omega.release()
+		}
+		h.mu.Lock()
+		unreserve()
+		h.mu.Unlock()
+		return err
+	}
+	for _, inst := range insts {
+		component, omega, err := h.create(inst)
+		if err != nil {
+			return abort(err)
+		}
+		node := DependencyNode{Name: inst.name}
+		if dependent, ok := component.(neomega_backbone.HasDependencies); ok {
+			node.Provides, node.Requires = dependent.Provides(), dependent.Requires()
+		}
+		byName[inst.name] = &created{inst: inst, component: component, omega: omega, node: node}
+	}
+
+	nodes := make([]DependencyNode, 0, len(byName))
+	for _, c := range byName {
+		nodes = append(nodes, c.node)
+	}
+	order, err := ResolveOrder(nodes, external)
+	if err != nil {
+		return abort(err)
+	}
+
+	// 某个能力的所有提供者都启动失败后, 依赖它的组件也会被跳过
+	providersLeft := map[neomega_backbone.Capability]int{}
+	for _, node := range nodes {
+		for _, p := range node.Provides {
+			providersLeft[capabilityKey(p)]++
+		}
+	}
+	externalCaps := map[neomega_backbone.Capability]bool{}
+	for _, c := range external {
+		externalCaps[capabilityKey(c)] = true
+	}
+	dependsOnFailed := func(node DependencyNode) (neomega_backbone.Capability, bool) {
+		for _, c := range node.Requires {
+			left, provided := providersLeft[capabilityKey(c)]
+			if provided && left == 0 && !c.Optional && !externalCaps[capabilityKey(c)] {
+				return c, true
+			}
+		}
+		return neomega_backbone.Capability{}, false
+	}
+	var errs []error
+	started := []*created{}
+	for _, name := range order {
+		c := byName[name]
+		if failedCap, ok := dependsOnFailed(c.node); ok {
+			c.omega.release()
+			errs = append(errs, fmt.Errorf("component_host: %v skipped, provider of %v failed to start", name, failedCap))
+		} else if err := beforeActivate(name, c.component, c.omega); err != nil {
+			errs = append(errs, err)
+		} else {
+			started = append(started, c)
+			continue
+		}
+		for _, p := range c.node.Provides {
+			providersLeft[capabilityKey(p)]--
+		}
+	}
+
+	launches := make([]*launched, len(started))
+	for i, c := range started {
+		launches[i] = newLaunched(c.component, c.omega)
+	}
+	h.mu.Lock()
+	var abandoned []int
+	for i, c := range started {
+		if h.instances[c.inst.name] == c.inst {
+			h.activate(c.inst, launches[i])
+			delete(reserved, c.inst.name)
+		} else {
+			abandoned = append(abandoned, i)
+		}
+	}
+	// 剩余的为启动失败或被跳过的组件
+	unreserve()
+	h.mu.Unlock()
+	for _, i := range abandoned {
+		errs = append(errs, h.abandon(started[i].inst, launches[i]))
+	}
+	return errors.Join(errs...)
+}
+
+// availableCapabilities 返回外部能力和已激活的组件提供的能力, 调用者需要持有锁
+// 已崩溃或正在重启的组件提供的能力不可用, Activate 已经正常返回的组件仍然提供其能力
+func (h *Host) availableCapabilities() []neomega_backbone.Capability {
+	caps := append([]neomega_backbone.Capability{}, h.external...)
+	for _, inst := range h.instances {
+		if inst.current != nil && inst.providing {
+			caps = append(caps, inst.current.provides...)
+		}
+	}
+	return caps
+}
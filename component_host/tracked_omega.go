@@ -0,0 +1,114 @@
+package component_host
+
+import (
+	"sync"
+	"sync/atomic"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+	"github.com/OmineDev/neomega-core/neomega"
+	"github.com/OmineDev/neomega-core/utils/async_wrapper"
+	"github.com/OmineDev/qq-bot-helper/packet"
+)
+
+// trackedOmega 是交给单个组件的 ExtendOmega, 记录组件注册的菜单项, api 和监听, 以便组件停止时注销它们
+// 监听和 api 经由 listenerHub 注册, 底层不支持移除的菜单项在组件停止后仍然存在, 但其回调不会再被调用
+type trackedOmega struct {
+	neomega_backbone.ExtendOmega
+	name  string
+	hub   *listenerHub
+	alive atomic.Bool
+
+	mu           sync.Mutex
+	gameMenus    []*neomega_backbone.GameMenuEntry
+	backendMenus []*neomega_backbone.BackendMenuEntry
+}
+
+func newTrackedOmega(name string, frame neomega_backbone.ExtendOmega, hub *listenerHub) *trackedOmega {
+	t := &trackedOmega{ExtendOmega: frame, name: name, hub: hub}
+	t.alive.Store(true)
+	return t
+}
+
+func (t *trackedOmega) AddGameMenuEntry(entry *neomega_backbone.GameMenuEntry) {
+	cb := entry.OnTrigCallBack
+	wrapped := &neomega_backbone.GameMenuEntry{MenuEntry: entry.MenuEntry}
+	if cb != nil {
+		wrapped.OnTrigCallBack = func(chat *neomega.GameChat) {
+			if t.alive.Load() {
+				cb(chat)
+			}
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// 与 release 共用锁, release 之后注册的菜单项不会被加入底层
+	if !t.alive.Load() {
+		return
+	}
+	t.gameMenus = append(t.gameMenus, wrapped)
+	t.ExtendOmega.AddGameMenuEntry(wrapped)
+}
+
+func (t *trackedOmega) AddBackendMenuEntry(entry *neomega_backbone.BackendMenuEntry) {
+	cb := entry.OnTrigCallBack
+	wrapped := &neomega_backbone.BackendMenuEntry{MenuEntry: entry.MenuEntry}
+	if cb != nil {
+		wrapped.OnTrigCallBack = func(cmds []string) {
+			if t.alive.Load() {
+				cb(cmds)
+			} else {
+				t.Out().Warning.Printfln("组件 %v 已停止", t.name)
+			}
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.alive.Load() {
+		return
+	}
+	t.backendMenus = append(t.backendMenus, wrapped)
+	t.ExtendOmega.AddBackendMenuEntry(wrapped)
+}
+
+func (t *trackedOmega) RegSoftAPI(cmd string) async_wrapper.AsyncAPISetHandler[neomega_backbone.CanGetData, []byte] {
+	return t.hub.regSoftAPI(t, cmd)
+}
+
+func (t *trackedOmega) RegInProcessAPI(apiName string) async_wrapper.AsyncAPISetHandler[any, any] {
+	return t.hub.regInProcessAPI(t, apiName)
+}
+
+func (t *trackedOmega) SoftListen(topic string, nonBlockingMsgHandleFn func(neomega_backbone.CanGetData)) {
+	t.hub.softListen(t, topic, nonBlockingMsgHandleFn)
+}
+
+func (t *trackedOmega) InProcessListen(topic string, onMsg func(any), newGoroutine bool) {
+	t.hub.inProcessListen(t, topic, onMsg, newGoroutine)
+}
+
+func (t *trackedOmega) RegisterPacketNoBlockCB(cb func(pk packet.CQPacket, data []byte)) {
+	t.hub.onPacket(t, cb)
+}
+
+func (t *trackedOmega) OnDefaultMessage(cb neomega_backbone.DefaultCQMessageCb) {
+	t.hub.onDefaultMessage(t, cb)
+}
+
+// release 使组件注册的所有回调失效, 移除其监听, 并在底层支持时移除菜单项和注销 api
+func (t *trackedOmega) release() {
+	t.alive.Store(false)
+	t.hub.release(t)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if remover, ok := t.ExtendOmega.(neomega_backbone.GameMenuRemover); ok {
+		for _, entry := range t.gameMenus {
+			remover.RemoveGameMenuEntry(entry)
+		}
+	}
+	if remover, ok := t.ExtendOmega.(neomega_backbone.BackendMenuRemover); ok {
+		for _, entry := range t.backendMenus {
+			remover.RemoveBackendMenuEntry(entry)
+		}
+	}
+	t.gameMenus, t.backendMenus = nil, nil
+}
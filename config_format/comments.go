@@ -0,0 +1,145 @@
+package config_format
+
+import (
+	"strings"
+
+	"github.com/OmineDev/neomega-backbone/config_schema"
+)
+
+// comment 是附着在某个 key(或数组元素) 上的注释, 不含注释符号
+type comment struct {
+	// key 之前的注释行
+	head []string
+	// 与值在同一行的注释
+	line string
+	// 容器(对象/数组/表)中最后一项之后的注释, 只用于容器本身的路径
+	foot []string
+}
+
+// path 以 json key 和数组下标表示, 根对象的路径为空
+func pathKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+func appendPath(path []string, seg string) []string {
+	return append(append(make([]string, 0, len(path)+1), path...), seg)
+}
+
+// annotations 记录旧文件中的注释和出现过的 key, 以及结构体中字段的说明
+type annotations struct {
+	comments    map[string]*comment
+	known       map[string]bool
+	hasPrevious bool
+	// key 为 schema 路径, 数组下标替换为 "*"
+	descriptions map[string]string
+}
+
+func newAnnotations() *annotations {
+	return &annotations{
+		comments:     map[string]*comment{},
+		known:        map[string]bool{},
+		descriptions: map[string]string{},
+	}
+}
+
+func (a *annotations) at(path []string) *comment {
+	key := pathKey(path)
+	c, ok := a.comments[key]
+	if !ok {
+		c = &comment{}
+		a.comments[key] = c
+	}
+	return c
+}
+
+func (a *annotations) addHead(path []string, lines []string) {
+	if len(lines) > 0 {
+		c := a.at(path)
+		c.head = append(c.head, lines...)
+	}
+}
+
+func (a *annotations) setLine(path []string, line string) {
+	if line != "" {
+		a.at(path).line = line
+	}
+}
+
+func (a *annotations) addFoot(path []string, lines []string) {
+	if len(lines) > 0 {
+		c := a.at(path)
+		c.foot = append(c.foot, lines...)
+	}
+}
+
+// addDescriptions 从 v 的结构体定义中收集 description tag
+func (a *annotations) addDescriptions(v any) {
+	schema, err := config_schema.Generate(v)
+	if err != nil {
+		return
+	}
+	var walk func(s *config_schema.Schema, path []string)
+	walk = func(s *config_schema.Schema, path []string) {
+		if s == nil {
+			return
+		}
+		if s.Description != "" && len(path) > 0 {
+			a.descriptions[pathKey(path)] = s.Description
+		}
+		for name, prop := range s.Properties {
+			walk(prop, appendPath(path, name))
+		}
+		walk(s.Items, appendPath(path, "*"))
+	}
+	walk(schema, nil)
+}
+
+// head 返回 path 之前应当写出的注释: 旧文件中的注释, 或者对于新出现的 key, 其字段说明
+// 数组中只有第一个元素的字段会带有说明
+func (a *annotations) head(path, schemaPath []string) []string {
+	key := pathKey(path)
+	if c := a.comments[key]; c != nil && len(c.head) > 0 {
+		return c.head
+	}
+	if a.hasPrevious && a.known[key] {
+		return nil
+	}
+	for i, seg := range schemaPath {
+		if seg == "*" && path[i] != "0" {
+			return nil
+		}
+	}
+	if d := a.descriptions[pathKey(schemaPath)]; d != "" {
+		return strings.Split(d, "\n")
+	}
+	return nil
+}
+
+func (a *annotations) line(path []string) string {
+	if c := a.comments[pathKey(path)]; c != nil {
+		return c.line
+	}
+	return ""
+}
+
+func (a *annotations) foot(path []string) []string {
+	if c := a.comments[pathKey(path)]; c != nil {
+		return c.foot
+	}
+	return nil
+}
+
+// stripMarker 去除注释行开头的注释符号和一个空格
+func stripMarker(line, marker string) string {
+	line = strings.TrimRight(line, "\r")
+	line = strings.TrimPrefix(strings.TrimLeft(line, " \t"), marker)
+	return strings.TrimPrefix(line, " ")
+}
+
+// withMarker 为注释行加上注释符号, 空行只保留注释符号
+func withMarker(line, marker string) string {
+	if line == "" {
+		return marker
+	}
+	return marker + " " + line
+}
@@ -0,0 +1,179 @@
+package config_format
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrUnknownFormat = errors.New("config_format: unknown config format")
+
+// Format 是一种配置文件格式
+// 配置结构体总是以 json tag 命名字段(与 .json 配置文件一致), 其他格式先转换为 json 再解析, 因此同一个结构体可以用于所有格式
+type Format interface {
+	Name() string
+	// Unmarshal 将 raw 解析到 v 中
+	Unmarshal(raw []byte, v any) error
+	// Marshal 编码 v, previous 为文件原来的内容(可以为 nil), 其中的注释会被保留到对应的 key 上
+	// 新出现的 key 若在结构体中有 description tag, 以注释的形式写出, 见 config_schema.Generate
+	// json 格式不支持注释, 会忽略 previous
+	Marshal(v any, previous []byte) ([]byte, error)
+}
+
+var formats = map[string]Format{
+	".json":  jsonFormat{},
+	".json5": json5Format{},
+	".yaml":  yamlFormat{},
+	".yml":   yamlFormat{},
+	".toml":  tomlFormat{},
+}
+
+// ForPath 根据扩展名选择格式: .json, .json5, .yaml/.yml, .toml
+func ForPath(path string) (Format, error) {
+	if f, ok := formats[strings.ToLower(filepath.Ext(path))]; ok {
+		return f, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnknownFormat, path)
+}
+
+// ReadFile 以 path 的扩展名对应的格式读取配置到 v 中
+func ReadFile(path string, v any) error {
+	f, err := ForPath(path)
+	if err != nil {
+		return err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("config_format: %v: %w", path, err)
+	}
+	return nil
+}
+
+// WriteFile 以 path 的扩展名对应的格式写入 v, 保留原文件中的注释
+// 先写入 path.tmp 再 rename, 避免写入中断时破坏原文件
+func WriteFile(path string, v any) error {
+	f, err := ForPath(path)
+	if err != nil {
+		return err
+	}
+	previous, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	raw, err := f.Marshal(v, previous)
+	if err != nil {
+		return fmt.Errorf("config_format: %v: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+type describedMarshaler interface {
+	marshalDescribed(v any, previous []byte, describedBy any) ([]byte, error)
+}
+
+// MarshalDescribed 与 f.Marshal 相同, 但字段说明取自 describedBy 的结构体定义
+// 用于 v 不是配置结构体本身的情况, e.g. 合并后得到的通用值
+func MarshalDescribed(f Format, v any, previous []byte, describedBy any) ([]byte, error) {
+	if d, ok := f.(describedMarshaler); ok {
+		return d.marshalDescribed(v, previous, describedBy)
+	}
+	return f.Marshal(v, previous)
+}
+
+// ToJSON 将 f 格式的 raw 转换为 json
+func ToJSON(f Format, raw []byte) (json.RawMessage, error) {
+	var converted json.RawMessage
+	if err := f.Unmarshal(raw, &converted); err != nil {
+		return nil, err
+	}
+	return converted, nil
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) Name() string { return "json" }
+
+func (jsonFormat) Unmarshal(raw []byte, v any) error {
+	return json.Unmarshal(raw, v)
+}
+
+func (jsonFormat) Marshal(v any, previous []byte) ([]byte, error) {
+	raw, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(raw, '\n'), nil
+}
+
+// unmarshalVia 将其他格式解析出的通用值(map, slice, 标量)经由 json 解析到 v 中
+func unmarshalVia(generic any, v any) error {
+	raw, err := json.Marshal(jsonCompatible(generic))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// jsonCompatible 将 key 不是 string 的 map(yaml 中可能出现) 转换为 map[string]any
+func jsonCompatible(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = jsonCompatible(item)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = jsonCompatible(item)
+		}
+		return m
+	case []any:
+		for i, item := range v {
+			v[i] = jsonCompatible(item)
+		}
+		return v
+	}
+	return v
+}
+
+// toNode 将 v 转换为保留字段顺序的 yaml.Node 树, 作为各格式编码时的中间表示
+// json 是 yaml 的子集, 因此直接以 yaml 解析 json.Marshal 的结果
+func toNode(v any) (*yaml.Node, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(raw, doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("config_format: empty value")
+	}
+	root := doc.Content[0]
+	resetStyle(root)
+	return root, nil
+}
+
+// resetStyle 去除 json 带来的 flow 风格和引号, 由编码器决定如何输出
+func resetStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		resetStyle(c)
+	}
+}
@@ -0,0 +1,305 @@
+package config_format
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testServer struct {
+	Host string `json:"主机" description:"服务器地址"`
+	Port int    `json:"port"`
+}
+
+type testConfig struct {
+	Name    string         `json:"name" description:"名称"`
+	Enabled bool           `json:"enabled"`
+	Ratio   float64        `json:"ratio"`
+	Tags    []string       `json:"tags"`
+	Servers []testServer   `json:"servers" description:"服务器列表"`
+	Extra   map[string]int `json:"extra"`
+	Nested  testServer     `json:"nested"`
+}
+
+// fixtures 为同一份配置在各个格式下由用户编辑过的样子, comments 为其中应当被保留的注释
+var fixtures = []struct {
+	ext      string
+	raw      string
+	comments []string
+}{
+	{
+		ext: ".json",
+		raw: `{
+	"name": "omega",
+	"enabled": true,
+	"ratio": 0.25,
+	"tags": ["a", "b"],
+	"servers": [{"主机": "h1", "port": 1}, {"主机": "h2", "port": 2}],
+	"extra": {"k-1": 1},
+	"nested": {"主机": "n", "port": 3}
+}
+`,
+	},
+	{
+		ext: ".json5",
+		raw: `// 文件开头
+{
+	// 名字
+	name: 'omega', // 行尾
+	enabled: true,
+	/* 块注释 */
+	ratio: .25,
+	tags: ['a', 'b',],
+	servers: [
+		// 第一台
+		{主机: "h1", port: 0x1},
+		{主机: "h2", port: +2},
+	],
+	extra: {'k-1': 1},
+	nested: {
+		主机: 'n',
+		port: 3,
+		// 嵌套末尾
+	},
+}
+// 文件末尾
+`,
+		comments: []string{"// 文件开头", "// 名字", "// 行尾", "// 块注释", "// 第一台", "// 嵌套末尾", "// 文件末尾"},
+	},
+	{
+		ext: ".yaml",
+		raw: `# 文件开头
+
+# 名字
+name: omega # 行尾
+enabled: true
+ratio: 0.25
+tags: [a, b]
+servers:
+  # 第一台
+  - 主机: h1
+    port: 1
+  - 主机: h2
+    port: 2
+extra:
+  k-1: 1
+nested:
+  主机: n
+  port: 3
+  # 嵌套末尾
+
+# 文件末尾
+`,
+		comments: []string{"# 文件开头", "# 名字", "# 行尾", "# 第一台", "# 嵌套末尾", "# 文件末尾"},
+	},
+	{
+		ext: ".toml",
+		raw: `# 名字
+name = 'omega' # 行尾
+enabled = true
+ratio = 0.25
+tags = ['a', 'b']
+
+# 第一台
+[[servers]]
+"主机" = "h1"
+port = 1
+
+[[servers]]
+"主机" = "h2"
+port = 2
+
+[extra]
+k-1 = 1
+
+# 嵌套表
+[nested] # 表头行尾
+"主机" = "n"
+port = 3
+
+# 文件末尾
+`,
+		comments: []string{"# 名字", "# 行尾", "# 第一台", "# 嵌套表", "# 表头行尾", "# 文件末尾"},
+	},
+}
+
+func mustFormat(t *testing.T, ext string) Format {
+	t.Helper()
+	f, err := ForPath("config" + ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, fixture := range fixtures {
+		t.Run(fixture.ext, func(t *testing.T) {
+			f := mustFormat(t, fixture.ext)
+			var typed testConfig
+			if err := f.Unmarshal([]byte(fixture.raw), &typed); err != nil {
+				t.Fatal(err)
+			}
+			var generic any
+			if err := f.Unmarshal([]byte(fixture.raw), &generic); err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range []any{typed, generic} {
+				encoded, err := f.Marshal(v, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				again := reflect.New(reflect.TypeOf(v)).Interface()
+				if err := f.Unmarshal(encoded, again); err != nil {
+					t.Fatalf("%v\n%s", err, encoded)
+				}
+				if got := reflect.ValueOf(again).Elem().Interface(); !reflect.DeepEqual(got, v) {
+					t.Fatalf("decode(encode(v)) = %#v, want %#v\n%s", got, v, encoded)
+				}
+				// 再次编码的结果应当不变
+				reencoded, err := f.Marshal(reflect.ValueOf(again).Elem().Interface(), encoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(reencoded) != string(encoded) {
+					t.Fatalf("encoding is not stable:\n%s\n---\n%s", encoded, reencoded)
+				}
+			}
+		})
+	}
+}
+
+func TestCommentsPreserved(t *testing.T) {
+	for _, fixture := range fixtures {
+		if len(fixture.comments) == 0 {
+			continue
+		}
+		t.Run(fixture.ext, func(t *testing.T) {
+			f := mustFormat(t, fixture.ext)
+			var c testConfig
+			if err := f.Unmarshal([]byte(fixture.raw), &c); err != nil {
+				t.Fatal(err)
+			}
+			c.Name = "changed"
+			c.Servers = append(c.Servers, testServer{Host: "h3", Port: 3})
+			encoded, err := f.Marshal(c, []byte(fixture.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, comment := range fixture.comments {
+				if !strings.Contains(string(encoded), comment) {
+					t.Errorf("comment %q lost:\n%s", comment, encoded)
+				}
+			}
+			// 用户文件中已有的 key 不会被加上字段说明
+			if strings.Contains(string(encoded), "名称") || strings.Contains(string(encoded), "服务器列表") {
+				t.Errorf("description added to existing key:\n%s", encoded)
+			}
+			var got testConfig
+			if err := f.Unmarshal(encoded, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c) {
+				t.Fatalf("got %#v, want %#v", got, c)
+			}
+		})
+	}
+}
+
+func TestDescriptionsForNewFile(t *testing.T) {
+	for _, fixture := range fixtures {
+		if fixture.ext == ".json" {
+			continue
+		}
+		t.Run(fixture.ext, func(t *testing.T) {
+			encoded, err := mustFormat(t, fixture.ext).Marshal(testConfig{Servers: []testServer{{}, {}}}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, description := range []string{"名称", "服务器列表", "服务器地址"} {
+				if !strings.Contains(string(encoded), description) {
+					t.Errorf("description %q missing:\n%s", description, encoded)
+				}
+			}
+			// 数组中只有第一个元素带有说明
+			if n := strings.Count(string(encoded), "服务器地址"); n != 2 {
+				t.Errorf("description repeated %v times:\n%s", n, encoded)
+			}
+		})
+	}
+}
+
+func TestTomlNull(t *testing.T) {
+	f := mustFormat(t, ".toml")
+	encoded, err := f.Marshal(map[string]any{"a": nil, "b": 1, "c": map[string]any{"d": nil}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := f.Unmarshal(encoded, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]any{"b": float64(1), "c": map[string]any{}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for _, v := range []any{
+		map[string]any{"a": []any{1, nil}},
+		map[string]any{"a": map[string]any{"b": []any{nil}}},
+	} {
+		if _, err := f.Marshal(v, nil); !errors.Is(err, ErrNullInToml) {
+			t.Errorf("Marshal(%v) = %v, want ErrNullInToml", v, err)
+		}
+	}
+}
+
+func TestJson5Syntax(t *testing.T) {
+	f := mustFormat(t, ".json5")
+	var got map[string]any
+	raw := `{a: 'x\
+y', b: [1, 2,], c: -0xff, d: 1.e3, $e: "é\x41", f: 5., 'g': null,}`
+	if err := f.Unmarshal([]byte(raw), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"a": "xy", "b": []any{float64(1), float64(2)}, "c": float64(-255), "d": float64(1000), "$e": "éA", "f": float64(5), "g": nil}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for _, bad := range []string{`{a: Infinity}`, `{a: -NaN}`, `{a: 1 b: 2}`, `{a: 'x`, `{/* a: 1}`} {
+		if err := f.Unmarshal([]byte(bad), &got); err == nil {
+			t.Errorf("Unmarshal(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestWriteFileKeepsComments(t *testing.T) {
+	dir := t.TempDir()
+	for _, fixture := range fixtures {
+		p := filepath.Join(dir, "config"+fixture.ext)
+		if err := os.WriteFile(p, []byte(fixture.raw), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var c testConfig
+		if err := ReadFile(p, &c); err != nil {
+			t.Fatal(err)
+		}
+		c.Ratio = 0.5
+		if err := WriteFile(p, c); err != nil {
+			t.Fatal(err)
+		}
+		written, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, comment := range fixture.comments {
+			if !strings.Contains(string(written), comment) {
+				t.Errorf("%v: comment %q lost:\n%s", fixture.ext, comment, written)
+			}
+		}
+	}
+	if err := WriteFile(filepath.Join(dir, "config.ini"), testConfig{}); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("got %v, want ErrUnknownFormat", err)
+	}
+}
@@ -0,0 +1,532 @@
+package config_format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+type json5Format struct{}
+
+func (json5Format) Name() string { return "json5" }
+
+func (json5Format) Unmarshal(raw []byte, v any) error {
+	generic, err := parseJson5(raw, newAnnotations())
+	if err != nil {
+		return err
+	}
+	return unmarshalVia(generic, v)
+}
+
+// json5Parser 解析 json5 为通用值(数字为 json.Number), 同时将注释记录到 a 中
+type json5Parser struct {
+	src     []byte
+	pos     int
+	a       *annotations
+	pending []string
+}
+
+func parseJson5(raw []byte, a *annotations) (any, error) {
+	p := &json5Parser{src: raw, a: a}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	a.addHead(nil, p.takePending())
+	v, err := p.value(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.trailing(nil); err != nil {
+		return nil, err
+	}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q after top level value", p.src[p.pos])
+	}
+	a.addFoot(nil, p.takePending())
+	return v, nil
+}
+
+func (p *json5Parser) errorf(format string, args ...any) error {
+	line := 1 + bytes.Count(p.src[:p.pos], []byte("\n"))
+	return fmt.Errorf("json5: line %v: %v", line, fmt.Sprintf(format, args...))
+}
+
+func (p *json5Parser) takePending() []string {
+	pending := p.pending
+	p.pending = nil
+	return pending
+}
+
+func (p *json5Parser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *json5Parser) hasPrefix(s string) bool {
+	return bytes.HasPrefix(p.src[p.pos:], []byte(s))
+}
+
+// comment 读取 p.pos 处的一个注释, 返回注释的各行(不含注释符号)以及其中是否有换行
+func (p *json5Parser) comment() (lines []string, multiline bool, err error) {
+	if p.hasPrefix("//") {
+		end := bytes.IndexByte(p.src[p.pos:], '\n')
+		if end < 0 {
+			end = len(p.src) - p.pos
+		}
+		line := string(p.src[p.pos : p.pos+end])
+		p.pos += end
+		return []string{stripMarker(line, "//")}, false, nil
+	}
+	end := bytes.Index(p.src[p.pos+2:], []byte("*/"))
+	if end < 0 {
+		return nil, false, p.errorf("unterminated comment")
+	}
+	body := string(p.src[p.pos+2 : p.pos+2+end])
+	p.pos += end + 4
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(strings.TrimRight(line, "\r"))
+		if line == "" && (len(lines) == 0) {
+			continue
+		}
+		lines = append(lines, stripMarker(line, "*"))
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, strings.Contains(body, "\n"), nil
+}
+
+// skip 跳过空白和注释, 注释被暂存到 pending 中, 由下一个 key 或容器的结尾认领
+func (p *json5Parser) skip() error {
+	for p.pos < len(p.src) {
+		r, size := utf8.DecodeRune(p.src[p.pos:])
+		switch {
+		case unicode.IsSpace(r) || r == '\uFEFF':
+			p.pos += size
+		case p.hasPrefix("//") || p.hasPrefix("/*"):
+			lines, _, err := p.comment()
+			if err != nil {
+				return err
+			}
+			p.pending = append(p.pending, lines...)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *json5Parser) skipInline() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.pos++
+	}
+}
+
+// lineComment 读取同一行内的注释作为 path 的行尾注释, 跨行的块注释属于下一个 key, 不在此处读取
+func (p *json5Parser) lineComment(path []string) error {
+	p.skipInline()
+	if !p.hasPrefix("//") && !p.hasPrefix("/*") {
+		return nil
+	}
+	start := p.pos
+	lines, multiline, err := p.comment()
+	if err != nil {
+		return err
+	}
+	if multiline {
+		p.pos = start
+		return nil
+	}
+	p.a.setLine(path, strings.Join(lines, " "))
+	p.skipInline()
+	return nil
+}
+
+// trailing 读取值之后的逗号和行尾注释, 返回是否读到了逗号
+func (p *json5Parser) trailing(path []string) (bool, error) {
+	if err := p.lineComment(path); err != nil {
+		return false, err
+	}
+	if p.peek() != ',' {
+		return false, nil
+	}
+	p.pos++
+	return true, p.lineComment(path)
+}
+
+func (p *json5Parser) value(path []string) (any, error) {
+	switch c := p.peek(); {
+	case c == '{':
+		return p.object(path)
+	case c == '[':
+		return p.array(path)
+	case c == '"' || c == '\'':
+		return p.string()
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+	case p.pos >= len(p.src):
+		return nil, p.errorf("unexpected end of input")
+	}
+	word := p.identifier()
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	case "Infinity", "NaN":
+		return nil, p.errorf("%v is not supported in config files", word)
+	}
+	return nil, p.errorf("unexpected %q", p.src[p.pos])
+}
+
+func (p *json5Parser) object(path []string) (any, error) {
+	p.pos++
+	obj := map[string]any{}
+	for {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.peek() == '}' {
+			p.pos++
+			p.a.addFoot(path, p.takePending())
+			return obj, nil
+		}
+		var key string
+		if c := p.peek(); c == '"' || c == '\'' {
+			s, err := p.string()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		} else if key = p.identifier(); key == "" {
+			return nil, p.errorf("expected key or '}', got %q", p.peek())
+		}
+		kp := appendPath(path, key)
+		p.a.known[pathKey(kp)] = true
+		p.a.addHead(kp, p.takePending())
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.peek() != ':' {
+			return nil, p.errorf("expected ':' after key %q", key)
+		}
+		p.pos++
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		p.pending = nil
+		v, err := p.value(kp)
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = v
+		if err := p.afterItem(kp, '}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *json5Parser) array(path []string) (any, error) {
+	p.pos++
+	arr := []any{}
+	for {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.peek() == ']' {
+			p.pos++
+			p.a.addFoot(path, p.takePending())
+			return arr, nil
+		}
+		ip := appendPath(path, strconv.Itoa(len(arr)))
+		p.a.known[pathKey(ip)] = true
+		p.a.addHead(ip, p.takePending())
+		v, err := p.value(ip)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+		if err := p.afterItem(ip, ']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// afterItem 消耗值之后的逗号和行尾注释, 没有逗号时下一个字符必须是 end
+func (p *json5Parser) afterItem(path []string, end byte) error {
+	if comma, err := p.trailing(path); err != nil || comma {
+		return err
+	}
+	if err := p.skip(); err != nil {
+		return err
+	}
+	if p.peek() == ',' {
+		p.pos++
+		return nil
+	}
+	if p.peek() != end {
+		return p.errorf("expected ',' or %q", end)
+	}
+	return nil
+}
+
+func isIdentRune(r rune, first bool) bool {
+	if r == '_' || r == '$' || unicode.IsLetter(r) {
+		return true
+	}
+	return !first && (unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r))
+}
+
+func (p *json5Parser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		r, size := utf8.DecodeRune(p.src[p.pos:])
+		if !isIdentRune(r, p.pos == start) {
+			break
+		}
+		p.pos += size
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *json5Parser) string() (string, error) {
+	quote := p.src[p.pos]
+	p.pos++
+	sb := strings.Builder{}
+	for {
+		if p.pos >= len(p.src) {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return sb.String(), nil
+		case c == '\n':
+			return "", p.errorf("unescaped newline in string")
+		case c != '\\':
+			sb.WriteByte(c)
+			p.pos++
+			continue
+		}
+		p.pos++
+		if p.pos >= len(p.src) {
+			return "", p.errorf("unterminated string")
+		}
+		c = p.src[p.pos]
+		p.pos++
+		switch c {
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '0':
+			sb.WriteByte(0)
+		case '\n':
+			// 行尾的 \ 表示续行
+		case '\r':
+			if p.peek() == '\n' {
+				p.pos++
+			}
+		case 'x', 'u':
+			n := 2
+			if c == 'u' {
+				n = 4
+			}
+			if p.pos+n > len(p.src) {
+				return "", p.errorf("bad escape")
+			}
+			code, err := strconv.ParseUint(string(p.src[p.pos:p.pos+n]), 16, 32)
+			if err != nil {
+				return "", p.errorf("bad escape \\%c%s", c, p.src[p.pos:p.pos+n])
+			}
+			p.pos += n
+			r := rune(code)
+			if utf16Surrogate(r) && p.hasPrefix("\\u") && p.pos+6 <= len(p.src) {
+				if low, err := strconv.ParseUint(string(p.src[p.pos+2:p.pos+6]), 16, 32); err == nil {
+					r = (r-0xD800)<<10 + (rune(low) - 0xDC00) + 0x10000
+					p.pos += 6
+				}
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+func utf16Surrogate(r rune) bool {
+	return r >= 0xD800 && r < 0xDC00
+}
+
+func (p *json5Parser) number() (any, error) {
+	start := p.pos
+	neg := false
+	if c := p.peek(); c == '+' || c == '-' {
+		neg = c == '-'
+		p.pos++
+	}
+	if word := p.identifier(); word != "" {
+		return nil, p.errorf("%v is not supported in config files", word)
+	}
+	if p.hasPrefix("0x") || p.hasPrefix("0X") {
+		p.pos += 2
+		digits := p.pos
+		for p.pos < len(p.src) && strings.IndexByte("0123456789abcdefABCDEF", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		n, ok := new(big.Int).SetString(string(p.src[digits:p.pos]), 16)
+		if !ok {
+			return nil, p.errorf("bad hex number %s", p.src[start:p.pos])
+		}
+		if neg {
+			n.Neg(n)
+		}
+		return json.Number(n.String()), nil
+	}
+	for p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	text := string(p.src[start:p.pos])
+	text = strings.TrimPrefix(text, "+")
+	if _, err := strconv.ParseFloat(text, 64); err != nil {
+		return nil, p.errorf("bad number %v", text)
+	}
+	// 转换为 json 允许的形式, e.g. .5 -> 0.5, 5. -> 5
+	body := strings.TrimPrefix(text, "-")
+	if strings.HasPrefix(body, ".") {
+		body = "0" + body
+	}
+	body = strings.Replace(body, ".e", "e", 1)
+	body = strings.Replace(body, ".E", "E", 1)
+	body = strings.TrimSuffix(body, ".")
+	if neg {
+		body = "-" + body
+	}
+	return json.Number(body), nil
+}
+
+type json5Writer struct {
+	buf *bytes.Buffer
+	a   *annotations
+}
+
+func (w *json5Writer) indent(depth int) {
+	w.buf.WriteString(strings.Repeat("\t", depth))
+}
+
+func (w *json5Writer) comments(lines []string, depth int) {
+	for _, line := range lines {
+		w.indent(depth)
+		w.buf.WriteString(withMarker(line, "//") + "\n")
+	}
+}
+
+func (w *json5Writer) lineEnd(path []string) {
+	if line := w.a.line(path); line != "" {
+		w.buf.WriteString(" " + withMarker(line, "//"))
+	}
+	w.buf.WriteString("\n")
+}
+
+func (w *json5Writer) value(n *yaml.Node, path, schemaPath []string, depth int) {
+	switch n.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		open, close := "{", "}"
+		step := 2
+		if n.Kind == yaml.SequenceNode {
+			open, close, step = "[", "]", 1
+		}
+		var foot []string
+		if depth > 0 {
+			// 根容器中的 foot 与文件末尾的注释一起写在最后
+			foot = w.a.foot(path)
+		}
+		if len(n.Content) == 0 && len(foot) == 0 {
+			w.buf.WriteString(open + close)
+			return
+		}
+		w.buf.WriteString(open + "\n")
+		for i := 0; i < len(n.Content); i += step {
+			var p, sp []string
+			item := n.Content[i]
+			if step == 2 {
+				p, sp = appendPath(path, item.Value), appendPath(schemaPath, item.Value)
+			} else {
+				p, sp = appendPath(path, strconv.Itoa(i)), appendPath(schemaPath, "*")
+			}
+			w.comments(w.a.head(p, sp), depth+1)
+			w.indent(depth + 1)
+			if step == 2 {
+				w.buf.WriteString(tomlString(item.Value) + ": ")
+				item = n.Content[i+1]
+			}
+			w.value(item, p, sp, depth+1)
+			if i+step < len(n.Content) {
+				w.buf.WriteString(",")
+			}
+			w.lineEnd(p)
+		}
+		w.comments(foot, depth+1)
+		w.indent(depth)
+		w.buf.WriteString(close)
+	case yaml.ScalarNode:
+		switch n.Tag {
+		case "!!str":
+			w.buf.WriteString(tomlString(n.Value))
+		case "!!null":
+			w.buf.WriteString("null")
+		default:
+			w.buf.WriteString(n.Value)
+		}
+	}
+}
+
+func (f json5Format) Marshal(v any, previous []byte) ([]byte, error) {
+	return f.marshalDescribed(v, previous, v)
+}
+
+func (json5Format) marshalDescribed(v any, previous []byte, describedBy any) ([]byte, error) {
+	root, err := toNode(v)
+	if err != nil {
+		return nil, err
+	}
+	a := newAnnotations()
+	if len(previous) > 0 {
+		// 旧文件无法解析时不保留其中的注释
+		prev := newAnnotations()
+		if _, err := parseJson5(previous, prev); err == nil {
+			a = prev
+			a.hasPrevious = true
+		}
+	}
+	a.addDescriptions(describedBy)
+	w := &json5Writer{buf: &bytes.Buffer{}, a: a}
+	w.comments(a.head(nil, nil), 0)
+	w.value(root, nil, nil, 0)
+	w.lineEnd(nil)
+	w.comments(a.foot(nil), 0)
+	return w.buf.Bytes(), nil
+}
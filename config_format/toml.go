@@ -0,0 +1,299 @@
+package config_format
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// tomlFormat 中 toml 没有 null: 值为 null 的 key(包括内联表中的) 被省略, 读回后与缺省该 key 相同
+// 数组中的 null 无法省略(会改变其他元素的下标), 因此返回 ErrNullInToml
+type tomlFormat struct{}
+
+var ErrNullInToml = errors.New("config_format: toml cannot represent null in arrays")
+
+func (tomlFormat) Name() string { return "toml" }
+
+func (tomlFormat) Unmarshal(raw []byte, v any) error {
+	generic := map[string]any{}
+	if err := toml.Unmarshal(raw, &generic); err != nil {
+		return err
+	}
+	return unmarshalVia(generic, v)
+}
+
+// tomlPathResolver 将 toml 中的 key 转换为带数组下标的路径, [[a]] 每出现一次, a 的下标加一
+type tomlPathResolver struct {
+	arrays map[string]int
+}
+
+func (r *tomlPathResolver) resolve(parts []string, newArrayElem bool) []string {
+	path := []string{}
+	for i, part := range parts {
+		path = appendPath(path, part)
+		key := pathKey(path)
+		idx, isArray := r.arrays[key]
+		if i == len(parts)-1 && newArrayElem {
+			if !isArray {
+				idx = -1
+			}
+			idx++
+			r.arrays[key] = idx
+			isArray = true
+		}
+		if isArray {
+			path = appendPath(path, strconv.Itoa(idx))
+		}
+	}
+	return path
+}
+
+func tomlKeyParts(n *unstable.Node) []string {
+	parts := []string{}
+	it := n.Key()
+	for it.Next() {
+		parts = append(parts, string(it.Node().Data))
+	}
+	return parts
+}
+
+func tomlLineComment(n *unstable.Node) string {
+	if next := n.Next(); next != nil && next.Kind == unstable.Comment {
+		return stripMarker(string(next.Data), "#")
+	}
+	return ""
+}
+
+// extractTomlComments 收集 previous 中的注释: key 或表头之前的注释行, 以及同一行的注释
+// 文件末尾剩余的注释作为根对象的 foot
+func extractTomlComments(previous []byte, a *annotations) error {
+	p := &unstable.Parser{KeepComments: true}
+	p.Reset(previous)
+	r := &tomlPathResolver{arrays: map[string]int{}}
+	table := []string{}
+	pending := []string{}
+	for p.NextExpression() {
+		expr := p.Expression()
+		var path []string
+		switch expr.Kind {
+		case unstable.Comment:
+			pending = append(pending, stripMarker(string(expr.Data), "#"))
+			continue
+		case unstable.KeyValue:
+			parts := tomlKeyParts(expr)
+			path = table
+			for _, part := range parts {
+				path = appendPath(path, part)
+				a.known[pathKey(path)] = true
+			}
+		case unstable.Table:
+			table = r.resolve(tomlKeyParts(expr), false)
+			path = table
+		case unstable.ArrayTable:
+			table = r.resolve(tomlKeyParts(expr), true)
+			path = table
+			a.known[pathKey(path[:len(path)-1])] = true
+		default:
+			continue
+		}
+		a.known[pathKey(path)] = true
+		a.addHead(path, pending)
+		a.setLine(path, tomlLineComment(expr))
+		pending = nil
+	}
+	if err := p.Error(); err != nil {
+		return err
+	}
+	a.addFoot(nil, pending)
+	return nil
+}
+
+var bareTomlKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(key string) string {
+	if bareTomlKey.MatchString(key) {
+		return key
+	}
+	return tomlString(key)
+}
+
+func tomlString(s string) string {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func isNull(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+// isArrayOfTables 判断 n 是否应当以 [[key]] 的形式写出
+func isArrayOfTables(n *yaml.Node) bool {
+	if n.Kind != yaml.SequenceNode || len(n.Content) == 0 {
+		return false
+	}
+	for _, item := range n.Content {
+		if item.Kind != yaml.MappingNode {
+			return false
+		}
+	}
+	return true
+}
+
+// tomlInline 以单行的形式写出值, 内联表中值为 null 的 key 被省略, 其他位置的 null 返回 ErrNullInToml
+func tomlInline(n *yaml.Node) (string, error) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		switch n.Tag {
+		case "!!str":
+			return tomlString(n.Value), nil
+		case "!!int", "!!float", "!!bool":
+			return n.Value, nil
+		case "!!null":
+			return "", ErrNullInToml
+		}
+		return "", fmt.Errorf("unsupported value %v", n.Value)
+	case yaml.SequenceNode:
+		items := []string{}
+		for _, item := range n.Content {
+			s, err := tomlInline(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case yaml.MappingNode:
+		items := []string{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if isNull(n.Content[i+1]) {
+				continue
+			}
+			s, err := tomlInline(n.Content[i+1])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, tomlKey(n.Content[i].Value)+" = "+s)
+		}
+		if len(items) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	}
+	return "", fmt.Errorf("unsupported node")
+}
+
+type tomlWriter struct {
+	buf *bytes.Buffer
+	a   *annotations
+}
+
+func (w *tomlWriter) comments(lines []string) {
+	for _, line := range lines {
+		w.buf.WriteString(withMarker(line, "#") + "\n")
+	}
+}
+
+func (w *tomlWriter) lineEnd(path []string) {
+	if line := w.a.line(path); line != "" {
+		w.buf.WriteString(" " + withMarker(line, "#"))
+	}
+	w.buf.WriteString("\n")
+}
+
+func (w *tomlWriter) separate() {
+	if w.buf.Len() > 0 {
+		w.buf.WriteString("\n")
+	}
+}
+
+// table 先写出 n 中的普通 key, 再写出子表和表数组, keys 为表头中使用的 key
+func (w *tomlWriter) table(n *yaml.Node, path, schemaPath, keys []string) error {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if isNull(v) || v.Kind == yaml.MappingNode || isArrayOfTables(v) {
+			continue
+		}
+		p, sp := appendPath(path, k.Value), appendPath(schemaPath, k.Value)
+		s, err := tomlInline(v)
+		if err != nil {
+			return fmt.Errorf("%v: %w", strings.Join(p, "."), err)
+		}
+		w.comments(w.a.head(p, sp))
+		w.buf.WriteString(tomlKey(k.Value) + " = " + s)
+		w.lineEnd(p)
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		p, sp := appendPath(path, k.Value), appendPath(schemaPath, k.Value)
+		subKeys := appendPath(keys, tomlKey(k.Value))
+		header := strings.Join(subKeys, ".")
+		if v.Kind == yaml.MappingNode {
+			w.separate()
+			w.comments(w.a.head(p, sp))
+			w.buf.WriteString("[" + header + "]")
+			w.lineEnd(p)
+			if err := w.table(v, p, sp, subKeys); err != nil {
+				return err
+			}
+		} else if isArrayOfTables(v) {
+			w.separate()
+			w.comments(w.a.head(p, sp))
+			for j, item := range v.Content {
+				ip, isp := appendPath(p, strconv.Itoa(j)), appendPath(sp, "*")
+				if j > 0 {
+					w.separate()
+				}
+				w.comments(w.a.head(ip, isp))
+				w.buf.WriteString("[[" + header + "]]")
+				w.lineEnd(ip)
+				if err := w.table(item, ip, isp, subKeys); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (f tomlFormat) Marshal(v any, previous []byte) ([]byte, error) {
+	return f.marshalDescribed(v, previous, v)
+}
+
+func (tomlFormat) marshalDescribed(v any, previous []byte, describedBy any) ([]byte, error) {
+	root, err := toNode(v)
+	if err != nil {
+		return nil, err
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("toml: top level value must be a table")
+	}
+	a := newAnnotations()
+	if len(previous) > 0 {
+		prev := newAnnotations()
+		if err := extractTomlComments(previous, prev); err == nil {
+			a = prev
+			a.hasPrevious = true
+		}
+	}
+	a.addDescriptions(describedBy)
+	w := &tomlWriter{buf: &bytes.Buffer{}, a: a}
+	if err := w.table(root, nil, nil, nil); err != nil {
+		return nil, err
+	}
+	if foot := a.foot(nil); len(foot) > 0 {
+		w.separate()
+		w.comments(foot)
+	}
+	return w.buf.Bytes(), nil
+}
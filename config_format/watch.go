@@ -0,0 +1,29 @@
+package config_format
+
+import (
+	"errors"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+var ErrWatchNotSupported = errors.New("config_format: storage does not support watching files")
+
+// WatchDynamicComponentConfig 在 configFile 于磁盘上被修改后重新读取它, 并以解析后的内容调用 cfg.Upgrade
+// configFile 的格式由扩展名决定, 见 ForPath; 读取, 解析或 Upgrade 失败时调用 onErr (可以为 nil), cfg 保持不变
+// storage 没有实现 StorageAndPathAccessWithWatch 时返回 ErrWatchNotSupported
+func WatchDynamicComponentConfig(storage neomega_backbone.StorageAndPathAccess, configFile string, cfg neomega_backbone.DynamicComponentConfig, onErr func(error)) (cancel func(), err error) {
+	watcher, ok := storage.(neomega_backbone.StorageAndPathAccessWithWatch)
+	if !ok {
+		return nil, ErrWatchNotSupported
+	}
+	return watcher.Watch(configFile, func(string) {
+		var newConfig any
+		err := ReadFile(configFile, &newConfig)
+		if err == nil {
+			err = cfg.Upgrade(newConfig)
+		}
+		if err != nil && onErr != nil {
+			onErr(err)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package config_format
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type yamlFormat struct{}
+
+func (yamlFormat) Name() string { return "yaml" }
+
+func (yamlFormat) Unmarshal(raw []byte, v any) error {
+	var generic any
+	if err := yaml.Unmarshal(raw, &generic); err != nil {
+		return err
+	}
+	return unmarshalVia(generic, v)
+}
+
+func splitYamlComment(c string) []string {
+	if c == "" {
+		return nil
+	}
+	lines := strings.Split(c, "\n")
+	for i, line := range lines {
+		lines[i] = stripMarker(line, "#")
+	}
+	return lines
+}
+
+func joinYamlComment(lines []string) string {
+	marked := make([]string, len(lines))
+	for i, line := range lines {
+		marked[i] = withMarker(line, "#")
+	}
+	return strings.Join(marked, "\n")
+}
+
+// extractYamlComments 收集 previous 中的注释, 注释的归属由 yaml.v3 决定
+func extractYamlComments(previous []byte, a *annotations) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(previous, doc); err != nil || len(doc.Content) == 0 {
+		return
+	}
+	a.hasPrevious = true
+	root := doc.Content[0]
+	a.addHead(nil, splitYamlComment(doc.HeadComment))
+	a.addHead(nil, splitYamlComment(root.HeadComment))
+	a.addFoot(nil, splitYamlComment(doc.FootComment))
+	var walk func(n *yaml.Node, path []string)
+	walk = func(n *yaml.Node, path []string) {
+		switch n.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				k, v := n.Content[i], n.Content[i+1]
+				p := appendPath(path, k.Value)
+				a.known[pathKey(p)] = true
+				a.addHead(p, splitYamlComment(k.HeadComment))
+				if k.LineComment != "" {
+					a.setLine(p, stripMarker(k.LineComment, "#"))
+				} else {
+					a.setLine(p, stripMarker(v.LineComment, "#"))
+				}
+				a.addFoot(path, splitYamlComment(k.FootComment))
+				walk(v, p)
+			}
+		case yaml.SequenceNode:
+			for i, item := range n.Content {
+				p := appendPath(path, strconv.Itoa(i))
+				a.known[pathKey(p)] = true
+				a.addHead(p, splitYamlComment(item.HeadComment))
+				a.setLine(p, stripMarker(item.LineComment, "#"))
+				a.addFoot(path, splitYamlComment(item.FootComment))
+				walk(item, p)
+			}
+		}
+	}
+	walk(root, nil)
+}
+
+func applyYamlComments(n *yaml.Node, path, schemaPath []string, a *annotations) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			p, sp := appendPath(path, k.Value), appendPath(schemaPath, k.Value)
+			k.HeadComment = joinYamlComment(a.head(p, sp))
+			if line := a.line(p); line != "" {
+				if v.Kind == yaml.ScalarNode {
+					v.LineComment = withMarker(line, "#")
+				} else {
+					k.LineComment = withMarker(line, "#")
+				}
+			}
+			applyYamlComments(v, p, sp, a)
+		}
+		if foot := a.foot(path); len(foot) > 0 && len(n.Content) > 0 && len(path) > 0 {
+			n.Content[len(n.Content)-2].FootComment = joinYamlComment(foot)
+		}
+	case yaml.SequenceNode:
+		for i, item := range n.Content {
+			p, sp := appendPath(path, strconv.Itoa(i)), appendPath(schemaPath, "*")
+			item.HeadComment = joinYamlComment(a.head(p, sp))
+			if line := a.line(p); line != "" && item.Kind == yaml.ScalarNode {
+				item.LineComment = withMarker(line, "#")
+			}
+			applyYamlComments(item, p, sp, a)
+		}
+		if foot := a.foot(path); len(foot) > 0 && len(n.Content) > 0 {
+			n.Content[len(n.Content)-1].FootComment = joinYamlComment(foot)
+		}
+	}
+}
+
+func (f yamlFormat) Marshal(v any, previous []byte) ([]byte, error) {
+	return f.marshalDescribed(v, previous, v)
+}
+
+func (yamlFormat) marshalDescribed(v any, previous []byte, describedBy any) ([]byte, error) {
+	root, err := toNode(v)
+	if err != nil {
+		return nil, err
+	}
+	a := newAnnotations()
+	a.addDescriptions(describedBy)
+	if len(previous) > 0 {
+		extractYamlComments(previous, a)
+	}
+	applyYamlComments(root, nil, nil, a)
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+	doc.HeadComment = joinYamlComment(a.head(nil, nil))
+	doc.FootComment = joinYamlComment(a.foot(nil))
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
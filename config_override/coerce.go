@@ -0,0 +1,111 @@
+package config_override
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType      = reflect.TypeOf(time.Duration(0))
+	textUnmarshalType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	jsonUnmarshalType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// coerce 将字符串 raw 转换为 v 的类型并写入 v
+// 标量按字面值解析, 数组/切片可以写作 json 或以逗号分隔, 其余复合类型需要写作 json
+func coerce(v reflect.Value, raw string) error {
+	if v.Kind() == reflect.Pointer {
+		if raw == "null" {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return coerce(v.Elem(), raw)
+	}
+	if v.CanAddr() {
+		if v.Addr().Type().Implements(jsonUnmarshalType) {
+			// 实现了 json.Unmarshaler 的类型, 先尝试把 raw 当作 json, 再当作 json 字符串
+			if err := json.Unmarshal([]byte(raw), v.Addr().Interface()); err == nil {
+				return nil
+			}
+			quoted, _ := json.Marshal(raw)
+			return json.Unmarshal(quoted, v.Addr().Interface())
+		}
+		if v.Addr().Type().Implements(textUnmarshalType) {
+			return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw))
+		}
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(raw, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice, reflect.Array:
+		if strings.HasPrefix(strings.TrimSpace(raw), "[") {
+			return unmarshalInto(v, raw)
+		}
+		parts := []string{}
+		if raw != "" {
+			parts = strings.Split(raw, ",")
+		}
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(v.Type(), len(parts), len(parts)))
+		} else if len(parts) > v.Len() {
+			return fmt.Errorf("too many elements for %v", v.Type())
+		}
+		for i, part := range parts {
+			if err := coerce(v.Index(i), strings.TrimSpace(part)); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+	default:
+		return unmarshalInto(v, raw)
+	}
+	return nil
+}
+
+func unmarshalInto(v reflect.Value, raw string) error {
+	p := reflect.New(v.Type())
+	if err := json.Unmarshal([]byte(raw), p.Interface()); err != nil {
+		return err
+	}
+	v.Set(p.Elem())
+	return nil
+}
@@ -0,0 +1,283 @@
+package config_override
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/OmineDev/neomega-backbone/config_format"
+)
+
+type Origin string
+
+const (
+	OriginDefault Origin = "default"
+	OriginFile    Origin = "file"
+	OriginEnv     Origin = "env"
+	OriginFlag    Origin = "flag"
+)
+
+const (
+	DefaultEnvPrefix = "OMEGA"
+	// FrameComponent 是 GetFrameConfigOverride 中框架配置使用的组件名, e.g. OMEGA_FRAME_XXX, --set frame.xxx=...
+	FrameComponent = "frame"
+)
+
+// override 是一条尚未应用的覆盖
+type override struct {
+	// 组件名, 已经过 normalize, 来自环境变量的覆盖项为空, 在 Apply 时才根据 envKey 确定其所属组件
+	component string
+	// 环境变量名中 <prefix>_ 之后的部分, e.g. MY_COMP_DB__MAX_CONN
+	envKey string
+	path   []string
+	value  string
+	origin Origin
+	// 环境变量名或命令行参数, 用于显示来源
+	source string
+}
+
+// Effective 是配置中一项的最终值及其来源
+type Effective struct {
+	// 以 json key 表示的路径, e.g. 名称, 列表.0.数量
+	Path   string
+	Value  any
+	Origin Origin
+	// 来自环境变量或命令行参数时, 为该变量名或参数
+	Source string
+}
+
+// Overrider 根据环境变量和 --set 参数覆盖配置中的值, 优先级为 flag > env > file > default
+//   - 环境变量: <prefix>_<COMPONENT>_<FIELD>, 嵌套的字段以 "__" 分隔, e.g. OMEGA_MYCOMP_DB__MAX_CONN=10
+//     组件名和字段名的比较忽略大小写和所有非字母数字的字符, 字段可以用 json key 或 go 字段名表示
+//   - 参数: --set <component>.<field>.<sub>=value, 可以出现多次, map 的 key 和数组的下标同样以 "." 分隔
+//
+// 一个组件名是另一个的前缀时(e.g. my 和 my-comp), 需要通过 SetComponents 告知所有组件名, 环境变量才能归属到名称最长的组件
+type Overrider struct {
+	overrides []override
+	// 已知的组件名, 见 SetComponents
+	components []string
+	// OnError 接收 GetFrameConfigOverride 中发生的错误, 为 nil 时输出到 stderr
+	OnError func(error)
+}
+
+// NewOverrider 从 environ(通常为 os.Environ()) 和 args(通常为 os.Args[1:]) 中读取覆盖项
+// 返回值 rest 为 args 中去除 --set 之后剩余的参数
+func NewOverrider(envPrefix string, environ []string, args []string) (o *Overrider, rest []string, err error) {
+	o = &Overrider{}
+	prefix := normalize(envPrefix) + "_"
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(strings.ToUpper(name), prefix) {
+			continue
+		}
+		o.overrides = append(o.overrides, override{
+			envKey: strings.ToUpper(name[len(prefix):]),
+			value:  value,
+			origin: OriginEnv,
+			source: name,
+		})
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var assignment string
+		switch {
+		case arg == "--set" || arg == "-set":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("config_override: %v requires key.path=value", arg)
+			}
+			i++
+			assignment = args[i]
+		case strings.HasPrefix(arg, "--set="):
+			assignment = strings.TrimPrefix(arg, "--set=")
+		case strings.HasPrefix(arg, "-set="):
+			assignment = strings.TrimPrefix(arg, "-set=")
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+		key, value, ok := strings.Cut(assignment, "=")
+		segs := strings.Split(key, ".")
+		if !ok || len(segs) < 2 {
+			return nil, nil, fmt.Errorf("config_override: invalid --set %q, expect component.field=value", assignment)
+		}
+		o.overrides = append(o.overrides, override{
+			component: normalize(segs[0]),
+			path:      segs[1:],
+			value:     value,
+			origin:    OriginFlag,
+			source:    "--set " + assignment,
+		})
+	}
+	return o, rest, nil
+}
+
+// SetComponents 设置所有组件的名称, 用于决定 OMEGA_MY_COMP_X 这样的环境变量属于 my 还是 my-comp
+// 未设置时, 环境变量属于任何名称为其前缀的组件
+func (o *Overrider) SetComponents(names ...string) {
+	o.components = append([]string{}, names...)
+}
+
+// Apply 将属于 component 的覆盖项写入 config(必须为指针), fileRaw 为 config 加载自的文件内容(可以为 nil),
+// fileFormat 为其格式(为 nil 时视为 json), 用于区分来自文件和默认的值, 返回 config 中所有项的最终值及来源
+func (o *Overrider) Apply(component string, config any, fileFormat config_format.Format, fileRaw []byte) ([]Effective, error) {
+	v := reflect.ValueOf(config)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return nil, fmt.Errorf("config_override: config must be a non-nil pointer, got %T", config)
+	}
+	applied := map[string]override{}
+	var errs []error
+	// 先 env 后 flag, 使 flag 覆盖 env
+	for _, origin := range []Origin{OriginEnv, OriginFlag} {
+		for _, ov := range o.overrides {
+			if ov.origin != origin {
+				continue
+			}
+			if origin == OriginEnv {
+				field, ok := o.envField(component, ov.envKey)
+				if !ok {
+					continue
+				}
+				ov.path = strings.Split(field, "__")
+			} else if ov.component != normalize(component) {
+				continue
+			}
+			path, err := set(v.Elem(), ov.path, ov.value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("config_override: %v: %w", ov.source, err))
+				continue
+			}
+			applied[strings.Join(path, ".")] = ov
+		}
+	}
+	var fileDoc any
+	if len(fileRaw) > 0 {
+		var err error
+		if fileFormat != nil {
+			err = fileFormat.Unmarshal(fileRaw, &fileDoc)
+		} else {
+			err = json.Unmarshal(fileRaw, &fileDoc)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config_override: parse config file: %w", err))
+		}
+	}
+	effective := []Effective{}
+	walk(v.Elem(), nil, func(path []string, value reflect.Value) {
+		key := strings.Join(path, ".")
+		if value.Kind() == reflect.Pointer && !value.IsNil() {
+			value = value.Elem()
+		}
+		e := Effective{Path: key, Value: value.Interface(), Origin: OriginDefault}
+		if ov, ok := overriddenBy(applied, key); ok {
+			e.Origin, e.Source = ov.origin, ov.source
+		} else if inDoc(fileDoc, path) {
+			e.Origin = OriginFile
+		}
+		effective = append(effective, e)
+	})
+	return effective, errors.Join(errs...)
+}
+
+// envField 判断环境变量 key(去除前缀后) 是否属于 component, 返回其中字段的部分
+// 其他已知组件的名称是 key 更长的前缀时(e.g. my-comp 之于 MY_COMP_X), key 不属于 component
+func (o *Overrider) envField(component, key string) (string, bool) {
+	n := envPrefixLen(component, key)
+	if n == 0 {
+		return "", false
+	}
+	for _, other := range o.components {
+		if normalize(other) != normalize(component) && envPrefixLen(other, key) > n {
+			return "", false
+		}
+	}
+	return key[n:], true
+}
+
+// envPrefixLen 返回 key 中组件名 component 及其后 "_" 的长度, key 不以 component 开头或其后没有字段时返回 0
+// 组件名中的非字母数字字符可以写作 "_" 或省略, e.g. 组件 my-comp 对应 MY_COMP_xxx 或 MYCOMP_xxx
+func envPrefixLen(component, key string) int {
+	underscored := strings.Builder{}
+	for _, r := range component {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			underscored.WriteRune(unicode.ToUpper(r))
+		} else {
+			underscored.WriteByte('_')
+		}
+	}
+	for _, prefix := range []string{underscored.String() + "_", normalize(component) + "_"} {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			return len(prefix)
+		}
+	}
+	return 0
+}
+
+// overriddenBy 查找覆盖了 key 或其上级的覆盖项
+func overriddenBy(applied map[string]override, key string) (override, bool) {
+	if ov, ok := applied[key]; ok {
+		return ov, true
+	}
+	for path, ov := range applied {
+		if strings.HasPrefix(key, path+".") || strings.HasPrefix(path, key+".") {
+			return ov, true
+		}
+	}
+	return override{}, false
+}
+
+func inDoc(doc any, path []string) bool {
+	for _, seg := range path {
+		m, ok := doc.(map[string]any)
+		if !ok {
+			return false
+		}
+		if doc, ok = m[seg]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// walk 以 json key 表示的路径遍历 v 中的每一项, 结构体被展开, 其余类型(包括 map 和切片)视为一项
+func walk(v reflect.Value, path []string, fn func(path []string, value reflect.Value)) {
+	for v.Kind() == reflect.Pointer && !v.IsNil() && v.Elem().Kind() == reflect.Struct {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct || len(path) > 0 && v.CanAddr() && v.Addr().Type().Implements(jsonUnmarshalType) {
+		fn(path, v)
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if isInlined(field) {
+			if fv := v.Field(i); fv.Kind() != reflect.Pointer || !fv.IsNil() {
+				walk(fv, path, fn)
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name := jsonName(field); name != "" {
+			walk(v.Field(i), append(append([]string{}, path...), name), fn)
+		}
+	}
+}
+
+// GetFrameConfigOverride 以组件名 FrameComponent 应用覆盖项, 可直接用于实现 ConfigProvider.GetFrameConfigOverride
+// 覆盖失败的项被跳过, 错误交给 OnError, config 不是指针时原样返回
+func (o *Overrider) GetFrameConfigOverride(config any) any {
+	if _, err := o.Apply(FrameComponent, config, nil, nil); err != nil {
+		if o.OnError != nil {
+			o.OnError(err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+	return config
+}
@@ -0,0 +1,139 @@
+package config_override
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// normalize 将名称转换为大写并去除所有非字母数字的字符, 用于宽松地比较环境变量, 字段名和 json key
+// e.g. "MaxPlayers", "max_players", "MAX_PLAYERS" 均为 "MAXPLAYERS"
+func normalize(name string) string {
+	b := strings.Builder{}
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// jsonName 返回字段在 json 中的名称, 字段不会出现在 json 中时返回 ""
+func jsonName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+// isInlined 判断字段是否为被 encoding/json 展开的匿名结构体
+func isInlined(field reflect.StructField) bool {
+	if !field.Anonymous {
+		return false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	t := field.Type
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return name == "" && t.Kind() == reflect.Struct
+}
+
+// findField 在结构体 v 中查找 json key 或字段名与 seg 相符的字段, 返回该字段及其 json key
+// 匿名结构体字段被展开查找, 其为 nil 指针时会被分配
+func findField(v reflect.Value, seg string) (reflect.Value, string, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if isInlined(field) {
+			fv := v.Field(i)
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					if !fv.CanSet() {
+						continue
+					}
+					fv.Set(reflect.New(fv.Type().Elem()))
+				}
+				fv = fv.Elem()
+			}
+			if found, name, ok := findField(fv, seg); ok {
+				return found, name, true
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name := jsonName(field)
+		if name == "" {
+			continue
+		}
+		if name == seg || normalize(name) == normalize(seg) || normalize(field.Name) == normalize(seg) {
+			return v.Field(i), name, true
+		}
+	}
+	return reflect.Value{}, "", false
+}
+
+// set 将 raw 写入 v 中 path 所指的位置, 返回该位置以 json key 表示的路径
+func set(v reflect.Value, path []string, raw string) ([]string, error) {
+	if len(path) == 0 {
+		return nil, coerce(v, raw)
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	seg := path[0]
+	switch v.Kind() {
+	case reflect.Struct:
+		field, name, ok := findField(v, seg)
+		if !ok {
+			return nil, fmt.Errorf("no field %q in %v", seg, v.Type())
+		}
+		rest, err := set(field, path[1:], raw)
+		return append([]string{name}, rest...), err
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %v", v.Type().Key())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		key := reflect.ValueOf(seg).Convert(v.Type().Key())
+		// map 中的值不可寻址, 修改副本后写回
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if old := v.MapIndex(key); old.IsValid() {
+			elem.Set(old)
+		}
+		rest, err := set(elem, path[1:], raw)
+		if err != nil {
+			return nil, err
+		}
+		v.SetMapIndex(key, elem)
+		return append([]string{seg}, rest...), nil
+	case reflect.Slice, reflect.Array:
+		i, err := strconv.Atoi(seg)
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("invalid index %q for %v", seg, v.Type())
+		}
+		if v.Kind() == reflect.Slice && i == v.Len() {
+			v.Set(reflect.Append(v, reflect.New(v.Type().Elem()).Elem()))
+		}
+		if i >= v.Len() {
+			return nil, fmt.Errorf("index %v out of range for %v", i, v.Type())
+		}
+		rest, err := set(v.Index(i), path[1:], raw)
+		return append([]string{seg}, rest...), err
+	}
+	return nil, fmt.Errorf("cannot descend into %v with %q", v.Type(), seg)
+}
@@ -0,0 +1,200 @@
+package config_registry
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+var ErrNotFound = errors.New("config_registry: config not found")
+
+// PersistFn 将 config 的启用状态写回其配置文件, 由 ConfigProvider 的实现提供
+type PersistFn func(config neomega_backbone.BasicConfig) error
+
+// Registry 记录所有组件的 BasicConfig, 可以作为 ConfigProvider 中 ConfigRead 部分的实现
+// e.g. 在 AddDefaultConfigFile 和配置文件被修改后调用 Put
+type Registry struct {
+	persist PersistFn
+
+	// 使 SetConfigDisabled 的读取, 写回和更新整体与 Put/Remove 串行, 避免期间 Put 的配置被旧的状态覆盖
+	writeMu sync.Mutex
+	mu      sync.RWMutex
+	names   []string
+	configs map[string]neomega_backbone.BasicConfig
+
+	subMu   sync.Mutex
+	nextSub int
+	subs    map[int]func(neomega_backbone.BasicConfig)
+}
+
+var _ neomega_backbone.ConfigRegistry = (*Registry)(nil)
+
+// NewRegistry 中 persist 可以为 nil, 此时 SetConfigDisabled 只修改内存中的状态
+func NewRegistry(persist PersistFn) *Registry {
+	return &Registry{
+		persist: persist,
+		configs: map[string]neomega_backbone.BasicConfig{},
+		subs:    map[int]func(neomega_backbone.BasicConfig){},
+	}
+}
+
+func clone(config neomega_backbone.BasicConfig) neomega_backbone.BasicConfig {
+	if config.Tags != nil {
+		config.Tags = append([]string{}, config.Tags...)
+	}
+	return config
+}
+
+// Put 添加或更新以 config.Name 为名的配置, 已有配置的启用状态变化时通知订阅者
+func (r *Registry) Put(config neomega_backbone.BasicConfig) {
+	r.writeMu.Lock()
+	changed := r.put(config)
+	r.writeMu.Unlock()
+	if changed {
+		r.notify(config)
+	}
+}
+
+// put 返回已有配置的启用状态是否发生了变化, 调用者需要持有 writeMu
+func (r *Registry) put(config neomega_backbone.BasicConfig) (changed bool) {
+	config = clone(config)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, existed := r.configs[config.Name]
+	if !existed {
+		r.names = append(r.names, config.Name)
+	}
+	r.configs[config.Name] = config
+	return existed && old.Disabled != config.Disabled
+}
+
+func (r *Registry) Remove(name string) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.configs[name]; !ok {
+		return
+	}
+	delete(r.configs, name)
+	for i, n := range r.names {
+		if n == name {
+			r.names = append(r.names[:i], r.names[i+1:]...)
+			break
+		}
+	}
+}
+
+func (r *Registry) Get(name string) (neomega_backbone.BasicConfig, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	config, ok := r.configs[name]
+	return clone(config), ok
+}
+
+func (r *Registry) QueryConfigs(query neomega_backbone.ConfigQuery) []neomega_backbone.BasicConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	matched := []neomega_backbone.BasicConfig{}
+	for _, name := range r.names {
+		config := r.configs[name]
+		if Match(query, &config) {
+			matched = append(matched, clone(config))
+		}
+	}
+	return matched
+}
+
+// Match 判断 c 是否满足 query 中所有不为空的条件, NamePattern 以 path.Match 匹配
+func Match(query neomega_backbone.ConfigQuery, c *neomega_backbone.BasicConfig) bool {
+	if query.Source != "" && c.Source != query.Source {
+		return false
+	}
+	if query.NamePattern != "" {
+		if ok, _ := path.Match(query.NamePattern, c.Name); !ok {
+			return false
+		}
+	}
+	if query.Tag != "" {
+		for _, tag := range c.Tags {
+			if tag == query.Tag {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (r *Registry) GetEnabledConfigBySource(source string) []string {
+	names := []string{}
+	for _, config := range r.QueryConfigs(neomega_backbone.ConfigQuery{Source: source}) {
+		if !config.Disabled {
+			names = append(names, config.Name)
+		}
+	}
+	return names
+}
+
+// SetConfigDisabled 先通过 PersistFn 写回配置文件, 写入失败时状态不变
+// 期间其他 goroutine 的 Put/Remove 会等待其完成, 因此 PersistFn 中不能调用 Put/Remove
+func (r *Registry) SetConfigDisabled(name string, disabled bool) error {
+	r.writeMu.Lock()
+	config, ok := r.Get(name)
+	if !ok {
+		r.writeMu.Unlock()
+		return fmt.Errorf("%w: %v", ErrNotFound, name)
+	}
+	if config.Disabled == disabled {
+		r.writeMu.Unlock()
+		return nil
+	}
+	config.Disabled = disabled
+	if r.persist != nil {
+		if err := r.persist(config); err != nil {
+			r.writeMu.Unlock()
+			return fmt.Errorf("config_registry: persist %v: %w", name, err)
+		}
+	}
+	changed := r.put(config)
+	r.writeMu.Unlock()
+	if changed {
+		r.notify(config)
+	}
+	return nil
+}
+
+func (r *Registry) SubscribeConfigState(onChange func(config neomega_backbone.BasicConfig)) (cancel func()) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+	id := r.nextSub
+	r.nextSub++
+	r.subs[id] = onChange
+	return func() {
+		r.subMu.Lock()
+		defer r.subMu.Unlock()
+		delete(r.subs, id)
+	}
+}
+
+// notify 在锁外调用订阅者, 因此订阅者中可以再次调用 Registry 的方法
+func (r *Registry) notify(config neomega_backbone.BasicConfig) {
+	r.subMu.Lock()
+	subs := make([]func(neomega_backbone.BasicConfig), 0, len(r.subs))
+	ids := make([]int, 0, len(r.subs))
+	for id := range r.subs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		subs = append(subs, r.subs[id])
+	}
+	r.subMu.Unlock()
+	for _, onChange := range subs {
+		onChange(clone(config))
+	}
+}
@@ -0,0 +1,54 @@
+package config_registry
+
+import (
+	"strings"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+// AddRegistryBackendMenu 注册终端菜单项 configs, 用于查看组件配置并启用/禁用组件
+func AddRegistryBackendMenu(r neomega_backbone.ConfigRegistry, backend neomega_backbone.BackendIO) {
+	out := backend.Out()
+	backend.AddBackendMenuEntry(&neomega_backbone.BackendMenuEntry{
+		MenuEntry: neomega_backbone.MenuEntry{
+			Triggers:     []string{"configs", "配置"},
+			ArgumentHint: "[enable|disable 组件名] [名称通配符]",
+			Usage:        "列出组件配置及其启用状态, 或启用/禁用指定组件",
+		},
+		OnTrigCallBack: func(cmds []string) {
+			if len(cmds) >= 2 {
+				var disabled bool
+				switch cmds[0] {
+				case "enable", "启用":
+					disabled = false
+				case "disable", "禁用":
+					disabled = true
+				default:
+					out.Warning.Printfln("未知操作 %v", cmds[0])
+					return
+				}
+				if err := r.SetConfigDisabled(cmds[1], disabled); err != nil {
+					out.Error.Printfln("%v", err)
+					return
+				}
+				out.Success.Printfln("%v %v 完成", cmds[0], cmds[1])
+				return
+			}
+			query := neomega_backbone.ConfigQuery{}
+			if len(cmds) == 1 {
+				query.NamePattern = cmds[0]
+			}
+			for _, config := range r.QueryConfigs(query) {
+				tags := ""
+				if len(config.Tags) > 0 {
+					tags = " [" + strings.Join(config.Tags, ", ") + "]"
+				}
+				if config.Disabled {
+					out.Warning.Printfln("%v (%v)%v 已禁用", config.Name, config.Source, tags)
+				} else {
+					out.Info.Printfln("%v (%v)%v 已启用", config.Name, config.Source, tags)
+				}
+			}
+		},
+	})
+}
@@ -0,0 +1,246 @@
+package config_schema
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Schema 是 JSON Schema(draft-07) 的一个子集, 足以描述由 encoding/json 读写的配置结构体
+type Schema struct {
+	SchemaURI   string             `json:"$schema,omitempty"`
+	Type        Types              `json:"type,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Default     any                `json:"default,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	// 按字段声明顺序排列的属性名, 用于稳定地输出错误和文档
+	PropertyOrder []string `json:"-"`
+	Required      []string `json:"required,omitempty"`
+	// 为 nil 时允许任意额外的 key (除非 ValidateOptions.DisallowUnknownKeys), map 类型时为值的 schema
+	AdditionalProperties *Schema  `json:"additionalProperties,omitempty"`
+	Items                *Schema  `json:"items,omitempty"`
+	Minimum              *float64 `json:"minimum,omitempty"`
+}
+
+// Types 只有一个元素时序列化为字符串, 否则为数组, e.g. "string" 或 ["array", "null"]
+type Types []string
+
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *Types) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = Types{single}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+func (t Types) has(name string) bool {
+	for _, tt := range t {
+		if tt == name {
+			return true
+		}
+	}
+	return false
+}
+
+const schemaURI = "http://json-schema.org/draft-07/schema#"
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Generate 根据 defaultConfig 的类型生成 schema, defaultConfig 中各字段的值作为 default
+// 属性名与 encoding/json 一致(json tag, 匿名结构体字段被展开), 字段的 description tag 作为说明,
+// required:"true" 的字段被列为必需
+// 实现了 json.Marshaler 的类型无法推断其格式, 不做任何约束
+func Generate(defaultConfig any) (*Schema, error) {
+	v := reflect.ValueOf(defaultConfig)
+	if !v.IsValid() {
+		return nil, fmt.Errorf("config_schema: cannot generate schema for nil")
+	}
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	s, err := generate(v.Type(), v, map[reflect.Type]bool{})
+	if err != nil {
+		return nil, err
+	}
+	s.SchemaURI = schemaURI
+	return s, nil
+}
+
+// generate 生成类型 t 的 schema, v 为其默认值(可以无效), visiting 用于检测递归类型
+func generate(t reflect.Type, v reflect.Value, visiting map[reflect.Type]bool) (*Schema, error) {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		nullable = true
+		t = t.Elem()
+		if v.IsValid() {
+			if v.IsNil() {
+				v = reflect.Value{}
+			} else {
+				v = v.Elem()
+			}
+		}
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		return &Schema{}, nil
+	}
+	if t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType) {
+		return withNull(&Schema{Type: Types{"string"}}, nullable), nil
+	}
+	s := &Schema{}
+	switch t.Kind() {
+	case reflect.Bool:
+		s.Type = Types{"boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s.Type = Types{"integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s.Type = Types{"integer"}
+		zero := float64(0)
+		s.Minimum = &zero
+	case reflect.Float32, reflect.Float64:
+		s.Type = Types{"number"}
+	case reflect.String:
+		s.Type = Types{"string"}
+	case reflect.Interface:
+		return &Schema{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte 被 encoding/json 编码为 base64 字符串
+			s.Type = Types{"string"}
+			break
+		}
+		s.Type = Types{"array"}
+		if t.Kind() == reflect.Slice {
+			nullable = true
+		}
+		items, err := generate(t.Elem(), reflect.Value{}, visiting)
+		if err != nil {
+			return nil, err
+		}
+		s.Items = items
+	case reflect.Map:
+		if k := t.Key().Kind(); k != reflect.String && !(k >= reflect.Int && k <= reflect.Uint64) && !t.Key().Implements(textMarshalerType) {
+			return nil, fmt.Errorf("config_schema: unsupported map key type %v", t.Key())
+		}
+		s.Type = Types{"object"}
+		nullable = true
+		values, err := generate(t.Elem(), reflect.Value{}, visiting)
+		if err != nil {
+			return nil, err
+		}
+		s.AdditionalProperties = values
+	case reflect.Struct:
+		if visiting[t] {
+			// 递归类型, 不再展开
+			return &Schema{Type: Types{"object"}}, nil
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		s.Type = Types{"object"}
+		s.Properties = map[string]*Schema{}
+		if err := addFields(s, t, v, visiting); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("config_schema: unsupported type %v", t)
+	}
+	// 结构体的默认值已经体现在各个属性中
+	if v.IsValid() && !v.IsZero() && t.Kind() != reflect.Struct {
+		s.Default = v.Interface()
+	}
+	return withNull(s, nullable), nil
+}
+
+func withNull(s *Schema, nullable bool) *Schema {
+	if nullable && len(s.Type) > 0 && !s.Type.has("null") {
+		s.Type = append(s.Type, "null")
+	}
+	return s
+}
+
+// addFields 将结构体 t 的字段加入 s, 规则与 encoding/json 相同
+func addFields(s *Schema, t reflect.Type, v reflect.Value, visiting map[reflect.Type]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		var fv reflect.Value
+		if v.IsValid() {
+			fv = v.Field(i)
+		}
+		ft := field.Type
+		if field.Anonymous && name == "" {
+			et := ft
+			if et.Kind() == reflect.Pointer {
+				et = et.Elem()
+				if fv.IsValid() {
+					if fv.IsNil() {
+						fv = reflect.Value{}
+					} else {
+						fv = fv.Elem()
+					}
+				}
+			}
+			if et.Kind() == reflect.Struct {
+				if err := addFields(s, et, fv, visiting); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fs, err := generate(ft, fv, visiting)
+		if err != nil {
+			return fmt.Errorf("%v.%v: %w", t.Name(), field.Name, err)
+		}
+		if strings.Contains(","+opts+",", ",string,") && len(fs.Type) > 0 {
+			fs.Type = Types{"string"}
+		}
+		fs.Description = field.Tag.Get("description")
+		if _, exist := s.Properties[name]; !exist {
+			s.PropertyOrder = append(s.PropertyOrder, name)
+		}
+		s.Properties[name] = fs
+		if field.Tag.Get("required") == "true" {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return nil
+}
+
+// sortedKeys 返回 m 中按 s.PropertyOrder 排列的已知 key, 以及按字典序排列的未知 key
+func (s *Schema) sortedKeys(m map[string]any) (known, unknown []string) {
+	for _, k := range s.PropertyOrder {
+		if _, ok := m[k]; ok {
+			known = append(known, k)
+		}
+	}
+	for k := range m {
+		if _, ok := s.Properties[k]; !ok {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return known, unknown
+}
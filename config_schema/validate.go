@@ -0,0 +1,208 @@
+package config_schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidConfig = errors.New("config_schema: invalid config")
+
+type ValidateOptions struct {
+	// 为 true 时, schema 中未声明的 key 视为错误(通常是拼错了的 key, e.g. "是否禁止" 而非 "是否禁用")
+	DisallowUnknownKeys bool
+}
+
+// ValidationError 描述一处不符合 schema 的值, Path 形如 $.组件配置.列表[2].名称
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+type ValidationErrors []*ValidationError
+
+func (es ValidationErrors) Error() string {
+	lines := make([]string, 0, len(es))
+	for _, e := range es {
+		lines = append(lines, e.Error())
+	}
+	return "config_schema: invalid config:\n  " + strings.Join(lines, "\n  ")
+}
+
+func (es ValidationErrors) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+// Validate 检查 raw(一个 json 文档) 是否符合 schema, 不符合时返回 ValidationErrors, 包含所有的错误而非仅第一个
+func Validate(schema *Schema, raw []byte, opts ValidateOptions) error {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	return ValidateValue(schema, v, opts)
+}
+
+// ValidateValue 检查已经解析的 json 值, e.g. DynamicComponentConfig.Upgrade 收到的参数
+// v 应当由 encoding/json 解析到 any 得到(数字为 float64 或 json.Number)
+func ValidateValue(schema *Schema, v any, opts ValidateOptions) error {
+	errs := ValidationErrors{}
+	validate(schema, v, "$", opts, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func typeMatches(types Types, actual string) bool {
+	if len(types) == 0 || types.has(actual) {
+		return true
+	}
+	// 整数也是合法的 number
+	return actual == "integer" && types.has("number")
+}
+
+func asFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func validate(s *Schema, v any, path string, opts ValidateOptions, errs *ValidationErrors) {
+	if s == nil {
+		return
+	}
+	actual := typeOf(v)
+	if !typeMatches(s.Type, actual) {
+		*errs = append(*errs, &ValidationError{Path: path, Message: fmt.Sprintf("expect %v, got %v", strings.Join(s.Type, " or "), actual)})
+		return
+	}
+	if s.Minimum != nil {
+		if f, ok := asFloat(v); ok && f < *s.Minimum {
+			*errs = append(*errs, &ValidationError{Path: path, Message: fmt.Sprintf("must be >= %v, got %v", *s.Minimum, v)})
+		}
+	}
+	switch v := v.(type) {
+	case []any:
+		for i, item := range v {
+			validate(s.Items, item, fmt.Sprintf("%v[%d]", path, i), opts, errs)
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, &ValidationError{Path: childPath(path, name), Message: "required but missing"})
+			}
+		}
+		known, unknown := s.sortedKeys(v)
+		for _, k := range known {
+			validate(s.Properties[k], v[k], childPath(path, k), opts, errs)
+		}
+		for _, k := range unknown {
+			if s.AdditionalProperties != nil {
+				validate(s.AdditionalProperties, v[k], childPath(path, k), opts, errs)
+			} else if opts.DisallowUnknownKeys && s.Properties != nil {
+				*errs = append(*errs, &ValidationError{Path: childPath(path, k), Message: "unknown key" + suggest(s, k)})
+			}
+		}
+	}
+}
+
+// childPath 对包含 . [ ] 等字符的 key 使用 ["key"] 的形式
+func childPath(path, key string) string {
+	if key == "" || strings.ContainsAny(key, `.[]"' `) {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	return path + "." + key
+}
+
+// suggest 在未知 key 与某个已知 key 足够接近时给出提示
+func suggest(s *Schema, key string) string {
+	best, bestDist := "", 3
+	for _, name := range s.PropertyOrder {
+		if d := editDistance(key, name); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = cur[j-1] + 1
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// Load 检查 raw 是否符合根据 config 生成的 schema, 通过后将其解析到 config 中, config 中原有的值作为默认值
+func Load(raw []byte, config any, opts ValidateOptions) error {
+	schema, err := Generate(config)
+	if err != nil {
+		return err
+	}
+	if err := Validate(schema, raw, opts); err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, config)
+}
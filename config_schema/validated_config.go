@@ -0,0 +1,39 @@
+package config_schema
+
+import (
+	"encoding/json"
+	"fmt"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+// ValidatedConfig 包装 DynamicComponentConfig, 新配置需要先通过 schema 检查才会交给 Upgrade
+// 可以与 config_format.WatchDynamicComponentConfig 一起使用, 使被改坏的配置文件不会影响正在运行的组件
+type ValidatedConfig struct {
+	neomega_backbone.DynamicComponentConfig
+	Schema  *Schema
+	Options ValidateOptions
+}
+
+var _ neomega_backbone.DynamicComponentConfig = (*ValidatedConfig)(nil)
+
+// NewValidatedConfig 根据 cfg.Configs() 生成 schema
+func NewValidatedConfig(cfg neomega_backbone.DynamicComponentConfig, opts ValidateOptions) (*ValidatedConfig, error) {
+	schema, err := Generate(cfg.Configs())
+	if err != nil {
+		return nil, err
+	}
+	return &ValidatedConfig{DynamicComponentConfig: cfg, Schema: schema, Options: opts}, nil
+}
+
+// Upgrade 的参数可以是已经解析的 json 值, 也可以是组件的配置结构体, 都先编码为 json 再检查
+func (c *ValidatedConfig) Upgrade(newConfig any) error {
+	raw, err := json.Marshal(newConfig)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if err := Validate(c.Schema, raw, c.Options); err != nil {
+		return err
+	}
+	return c.DynamicComponentConfig.Upgrade(newConfig)
+}
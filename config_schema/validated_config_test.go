@@ -0,0 +1,64 @@
+package config_schema
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type testConfig struct {
+	Name  string `json:"名称"`
+	Count int    `json:"数量"`
+}
+
+// recordingConfig 记录 Upgrade 收到的配置
+type recordingConfig struct {
+	current  testConfig
+	upgraded []any
+}
+
+func (c *recordingConfig) Configs() any { return &c.current }
+
+func (c *recordingConfig) Upgrade(newConfig any) error {
+	c.upgraded = append(c.upgraded, newConfig)
+	return nil
+}
+
+func TestValidatedConfigUpgrade(t *testing.T) {
+	inner := &recordingConfig{current: testConfig{Name: "a", Count: 1}}
+	cfg, err := NewValidatedConfig(inner, ValidateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typed := testConfig{Name: "b", Count: 2}
+	if err := cfg.Upgrade(typed); err != nil {
+		t.Fatalf("typed config: %v", err)
+	}
+	if err := cfg.Upgrade(&typed); err != nil {
+		t.Fatalf("pointer to typed config: %v", err)
+	}
+	var decoded any
+	if err := json.Unmarshal([]byte(`{"名称": "c", "数量": 3}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Upgrade(decoded); err != nil {
+		t.Fatalf("decoded config: %v", err)
+	}
+	if len(inner.upgraded) != 3 {
+		t.Fatalf("upgraded %v times, want 3", len(inner.upgraded))
+	}
+
+	if err := json.Unmarshal([]byte(`{"名称": "d", "数量": "多"}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Upgrade(decoded); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("got %v, want ErrInvalidConfig", err)
+	}
+	if err := cfg.Upgrade(func() {}); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("got %v, want ErrInvalidConfig", err)
+	}
+	if len(inner.upgraded) != 3 {
+		t.Fatal("invalid config was passed to Upgrade")
+	}
+}
@@ -0,0 +1,70 @@
+package config_upgrade
+
+// Conflict 记录默认值和用户都修改了的项, 合并时保留用户的值
+type Conflict struct {
+	Path    string
+	User    any
+	Default any
+}
+
+// merge 对 base(上次写入的默认配置), ours(用户当前的配置), theirs(新的默认配置) 做三方合并:
+//   - 用户未修改的项(ours==base) 更新为新的默认值
+//   - 用户修改过的项保留用户的值, 若默认值也变化了, 记录为 Conflict
+//   - 新增的默认项被加入, 用户删除的默认项保持删除, 用户添加的项保持不变
+//   - 默认配置中移除的项, 若用户未修改过则一并移除
+//
+// 对象逐个 key 递归合并, 数组视为一个整体
+// hasBase 为 false 时(没有记录上次的默认配置), ours 中的所有项都视为用户修改过的
+func merge(base any, hasBase bool, ours, theirs any, path string, conflicts *[]Conflict) any {
+	oursObj, oursIsObj := ours.(*object)
+	theirsObj, theirsIsObj := theirs.(*object)
+	if oursIsObj && theirsIsObj {
+		baseObj, _ := base.(*object)
+		if !hasBase {
+			baseObj = nil
+		}
+		return mergeObject(baseObj, oursObj, theirsObj, path, conflicts)
+	}
+	if hasBase && equal(ours, base) {
+		return theirs
+	}
+	if hasBase && !equal(theirs, base) && !equal(ours, theirs) {
+		*conflicts = append(*conflicts, Conflict{Path: path, User: ours, Default: theirs})
+	}
+	return ours
+}
+
+func mergeObject(base, ours, theirs *object, path string, conflicts *[]Conflict) *object {
+	merged := newObject()
+	for _, k := range theirs.keys {
+		tv := theirs.values[k]
+		ov, inOurs := ours.get(k)
+		var bv any
+		inBase := false
+		if base != nil {
+			bv, inBase = base.get(k)
+		}
+		switch {
+		case inOurs:
+			merged.set(k, merge(bv, inBase, ov, tv, path+"."+k, conflicts))
+		case inBase:
+			// 用户删除了该项
+		default:
+			merged.set(k, tv)
+		}
+	}
+	for _, k := range ours.keys {
+		if _, inTheirs := theirs.get(k); inTheirs {
+			continue
+		}
+		ov := ours.values[k]
+		if base != nil {
+			if bv, inBase := base.get(k); inBase && equal(ov, bv) {
+				// 默认配置移除了该项, 用户也没有修改过
+				continue
+			}
+		}
+		merged.set(k, ov)
+	}
+	return merged
+}
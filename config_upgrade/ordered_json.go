@@ -0,0 +1,195 @@
+package config_upgrade
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// object 是保留 key 顺序的 json 对象, 配置文件重写后 key 的顺序应当与用户看到的保持一致
+type object struct {
+	keys   []string
+	values map[string]any
+}
+
+func newObject() *object {
+	return &object{values: map[string]any{}}
+}
+
+func (o *object) get(key string) (any, bool) {
+	v, ok := o.values[key]
+	return v, ok
+}
+
+func (o *object) set(key string, v any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+// MarshalJSON 按 key 的顺序编码, 使其他格式写出的 key 顺序与 json 一致
+func (o *object) MarshalJSON() ([]byte, error) {
+	return encodeOrdered(o)
+}
+
+// decodeOrdered 解析 raw, 对象被解析为 *object, 数字为 json.Number
+func decodeOrdered(raw []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	v, err := decodeValue(d)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, fmt.Errorf("config_upgrade: unexpected data after json value")
+	}
+	return v, nil
+}
+
+func decodeValue(d *json.Decoder) (any, error) {
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		o := newObject()
+		for d.More() {
+			keyTok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeValue(d)
+			if err != nil {
+				return nil, err
+			}
+			o.set(keyTok.(string), v)
+		}
+		_, err := d.Token()
+		return o, err
+	case json.Delim('['):
+		arr := []any{}
+		for d.More() {
+			v, err := decodeValue(d)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := d.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// encodeOrdered 以 tab 缩进编码 v, 格式与 json.MarshalIndent(v, "", "\t") 相同, 但不转义 html 字符
+func encodeOrdered(v any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := encodeValue(buf, v, 0); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func encodeScalar(buf *bytes.Buffer, v any) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode 会在末尾追加换行
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+func encodeValue(buf *bytes.Buffer, v any, depth int) error {
+	indent := strings.Repeat("\t", depth+1)
+	switch v := v.(type) {
+	case *object:
+		if len(v.keys) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i, k := range v.keys {
+			buf.WriteString(indent)
+			if err := encodeScalar(buf, k); err != nil {
+				return err
+			}
+			buf.WriteString(": ")
+			if err := encodeValue(buf, v.values[k], depth+1); err != nil {
+				return err
+			}
+			if i < len(v.keys)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent[1:] + "}")
+	case []any:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, item := range v {
+			buf.WriteString(indent)
+			if err := encodeValue(buf, item, depth+1); err != nil {
+				return err
+			}
+			if i < len(v)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent[1:] + "]")
+	default:
+		return encodeScalar(buf, v)
+	}
+	return nil
+}
+
+// equal 比较两个由 decodeOrdered 得到的值, 忽略对象中 key 的顺序
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case *object:
+		b, ok := b.(*object)
+		if !ok || len(a.values) != len(b.values) {
+			return false
+		}
+		for k, av := range a.values {
+			bv, ok := b.values[k]
+			if !ok || !equal(av, bv) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if a == b {
+			return true
+		}
+		af, aErr := a.Float64()
+		bf, bErr := b.Float64()
+		return aErr == nil && bErr == nil && af == bf
+	}
+	return a == b
+}
@@ -0,0 +1,335 @@
+package config_upgrade
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+	"github.com/OmineDev/neomega-backbone/config_format"
+)
+
+// versionKey 是 BasicConfig.Version 在配置文件中的 key
+const versionKey = "配置版本"
+
+// UpgradeFn 将组件的配置从 fromVersion 升级到 fromVersion+1, 用于字段改名, 结构调整等无法通过合并完成的修改
+type UpgradeFn func(old json.RawMessage) (new json.RawMessage, err error)
+
+type Result struct {
+	// 配置文件原本不存在, 已写入默认配置
+	Created bool
+	// 配置文件已被重写, 原文件备份于 Backup
+	Rewritten   bool
+	Backup      string
+	FromVersion int
+	ToVersion   int
+	// 默认值和用户都修改了的项, 保留了用户的值
+	Conflicts []Conflict
+}
+
+// DefaultKeepBackups 是 NewUpgrader 默认为每个配置文件保留的备份数量
+const DefaultKeepBackups = 5
+
+type Upgrader struct {
+	mu     sync.RWMutex
+	chains map[string]map[int]UpgradeFn
+	// KeepBackups 为每个配置文件在 .backup/ 下保留的最近备份数量, 不为正数时保留所有备份
+	KeepBackups int
+}
+
+func NewUpgrader() *Upgrader {
+	return &Upgrader{chains: map[string]map[int]UpgradeFn{}, KeepBackups: DefaultKeepBackups}
+}
+
+// RegisterUpgrade 注册组件 component(即 BasicConfig.Name) 的配置从 fromVersion 升级到 fromVersion+1 的函数
+// 组件配置的当前版本为所有已注册升级中最大的 fromVersion+1
+func (u *Upgrader) RegisterUpgrade(component string, fromVersion int, fn UpgradeFn) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.chains[component] == nil {
+		u.chains[component] = map[int]UpgradeFn{}
+	}
+	u.chains[component][fromVersion] = fn
+}
+
+func (u *Upgrader) CurrentVersion(component string) int {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.currentVersion(component)
+}
+
+func (u *Upgrader) currentVersion(component string) int {
+	version := 0
+	for from := range u.chains[component] {
+		if from+1 > version {
+			version = from + 1
+		}
+	}
+	return version
+}
+
+func versionOf(o *object) (int, error) {
+	v, ok := o.get(versionKey)
+	if !ok {
+		return 0, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("config_upgrade: %v should be an integer, got %v", versionKey, v)
+	}
+	version, err := strconv.Atoi(string(n))
+	if err != nil {
+		return 0, fmt.Errorf("config_upgrade: %v should be an integer, got %v", versionKey, n)
+	}
+	return version, nil
+}
+
+// upgrade 将 o 升级到 component 的当前版本
+func (u *Upgrader) upgrade(component string, o *object) (*object, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	current := u.currentVersion(component)
+	version, err := versionOf(o)
+	if err != nil {
+		return nil, err
+	}
+	if version > current {
+		return nil, fmt.Errorf("config_upgrade: config of %v has version %v, newer than current version %v", component, version, current)
+	}
+	for ; version < current; version++ {
+		fn, ok := u.chains[component][version]
+		if !ok {
+			return nil, fmt.Errorf("config_upgrade: no upgrade registered for %v from version %v", component, version)
+		}
+		raw, err := encodeOrdered(o)
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = fn(raw); err != nil {
+			return nil, fmt.Errorf("config_upgrade: upgrade %v from version %v: %w", component, version, err)
+		}
+		v, err := decodeOrdered(raw)
+		if err != nil {
+			return nil, fmt.Errorf("config_upgrade: upgrade %v from version %v: %w", component, version, err)
+		}
+		if o, ok = v.(*object); !ok {
+			return nil, fmt.Errorf("config_upgrade: upgrade %v from version %v did not return an object", component, version)
+		}
+	}
+	setVersion(o, current)
+	return o, nil
+}
+
+// setVersion 设置 o 的版本, 版本为 0 且 o 中没有该项时不添加, 与 BasicConfig.Version 的 omitempty 一致
+func setVersion(o *object, version int) {
+	if _, ok := o.get(versionKey); ok || version != 0 {
+		o.set(versionKey, json.Number(strconv.Itoa(version)))
+	}
+}
+
+// defaultsPath 是上次写入的默认配置的存放位置, 作为三方合并的 base
+func defaultsPath(path string) string {
+	return filepath.Join(filepath.Dir(path), ".defaults", filepath.Base(path)+".default")
+}
+
+const backupTimeFormat = "20060102-150405.000"
+
+func backupPath(path string, now time.Time) string {
+	return filepath.Join(filepath.Dir(path), ".backup", filepath.Base(path)+"."+now.Format(backupTimeFormat)+".bak")
+}
+
+// pruneBackups 删除 path 在 .backup/ 下较旧的备份, 只保留最近的 keep 个
+func pruneBackups(path string, keep int) error {
+	dir := filepath.Join(filepath.Dir(path), ".backup")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	prefix := filepath.Base(path) + "."
+	backups := []string{}
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok {
+			continue
+		}
+		if stamp, ok = strings.CutSuffix(stamp, ".bak"); !ok {
+			continue
+		}
+		// 排除其他以 path 为前缀的配置文件的备份, e.g. a.json 与 a.json.old
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, entry.Name())
+		}
+	}
+	// 时间格式使得按名称排序即按时间排序
+	sort.Strings(backups)
+	var errs []error
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			errs = append(errs, err)
+		}
+		backups = backups[1:]
+	}
+	return errors.Join(errs...)
+}
+
+func readObject(path string) (*object, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseObject(path, raw)
+}
+
+func parseObject(path string, raw []byte) (*object, error) {
+	v, err := decodeOrdered(raw)
+	if err != nil {
+		return nil, fmt.Errorf("config_upgrade: %v: %w", path, err)
+	}
+	o, ok := v.(*object)
+	if !ok {
+		return nil, fmt.Errorf("config_upgrade: %v is not a json object", path)
+	}
+	return o, nil
+}
+
+// formatOf 返回 path 的扩展名对应的非 json 格式, .json 和无法识别的扩展名均按 json 处理
+func formatOf(path string) config_format.Format {
+	f, err := config_format.ForPath(path)
+	if err != nil || f.Name() == "json" {
+		return nil
+	}
+	return f
+}
+
+// toJSON 将用户的配置文件转换为 json, 以便与 json 的默认配置合并
+func toJSON(path string, raw []byte) ([]byte, error) {
+	f := formatOf(path)
+	if f == nil {
+		return raw, nil
+	}
+	converted, err := config_format.ToJSON(f, raw)
+	if err != nil {
+		return nil, fmt.Errorf("config_upgrade: %v: %w", path, err)
+	}
+	return converted, nil
+}
+
+// encodeFor 以 path 的格式编码 v, 非 json 格式会保留 previous 中的注释, 新增项的说明取自 fullConfig
+func encodeFor(path string, v any, previous []byte, fullConfig any) ([]byte, error) {
+	f := formatOf(path)
+	if f == nil {
+		return encodeOrdered(v)
+	}
+	return config_format.MarshalDescribed(f, v, previous, fullConfig)
+}
+
+func writeFileWithTMP(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// SyncConfigFile 使 path 处的配置文件与 fullConfig(组件当前的默认配置) 保持同步, 通常在 ConfigWrite.AddDefaultConfigFile 中调用
+//   - 文件不存在时写入默认配置
+//   - 否则先通过已注册的 UpgradeFn 将用户配置升级到当前版本, 然后与新的默认配置做三方合并(见 merge)
+//   - 结果与原文件不同时, 原文件被备份到 .backup/ 下(只保留最近的 KeepBackups 个), 然后重写
+//
+// 配置文件的格式由扩展名决定(见 config_format), 以 json5/yaml/toml 重写时保留用户的注释
+//
+// 每次写入的默认配置被保存在 .defaults/ 下, 作为下次合并的 base
+// basicConfig.Version 会被设置为组件配置的当前版本
+func (u *Upgrader) SyncConfigFile(path string, basicConfig *neomega_backbone.BasicConfig, fullConfig any) (*Result, error) {
+	current := u.CurrentVersion(basicConfig.Name)
+	basicConfig.Version = current
+	raw, err := json.Marshal(fullConfig)
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeOrdered(raw)
+	if err != nil {
+		return nil, err
+	}
+	theirs, ok := v.(*object)
+	if !ok {
+		return nil, fmt.Errorf("config_upgrade: default config of %v is not a json object", basicConfig.Name)
+	}
+	setVersion(theirs, current)
+	defaults, err := encodeOrdered(theirs)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{ToVersion: current}
+
+	userRaw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		created, err := encodeFor(path, theirs, nil, fullConfig)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileWithTMP(path, created); err != nil {
+			return nil, err
+		}
+		result.Created = true
+		result.FromVersion = current
+		return result, writeFileWithTMP(defaultsPath(path), defaults)
+	}
+	if err != nil {
+		return nil, err
+	}
+	userJSON, err := toJSON(path, userRaw)
+	if err != nil {
+		return nil, err
+	}
+	// upgrade 会原地修改 ours, 因此解析两次
+	original, err := parseObject(path, userJSON)
+	if err != nil {
+		return nil, err
+	}
+	ours, _ := parseObject(path, userJSON)
+	if result.FromVersion, err = versionOf(ours); err != nil {
+		return nil, err
+	}
+	if ours, err = u.upgrade(basicConfig.Name, ours); err != nil {
+		return nil, err
+	}
+	// base 缺失或无法升级时, 视为用户修改了所有项, 只补充新增的默认项
+	var base any
+	hasBase := false
+	if baseObj, err := readObject(defaultsPath(path)); err == nil {
+		if baseObj, err = u.upgrade(basicConfig.Name, baseObj); err == nil {
+			base, hasBase = baseObj, true
+		}
+	}
+	merged := merge(base, hasBase, ours, theirs, "$", &result.Conflicts)
+	var pruneErr error
+	if !equal(merged, original) {
+		mergedRaw, err := encodeFor(path, merged, userRaw, fullConfig)
+		if err != nil {
+			return nil, err
+		}
+		result.Backup = backupPath(path, time.Now())
+		if err := writeFileWithTMP(result.Backup, userRaw); err != nil {
+			return nil, err
+		}
+		if err := writeFileWithTMP(path, mergedRaw); err != nil {
+			return nil, err
+		}
+		result.Rewritten = true
+		if u.KeepBackups > 0 {
+			// 清理失败不影响已经完成的重写, 与写入 .defaults/ 的错误一起返回
+			pruneErr = pruneBackups(path, u.KeepBackups)
+		}
+	}
+	return result, errors.Join(pruneErr, writeFileWithTMP(defaultsPath(path), defaults))
+}
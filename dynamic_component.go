@@ -1,5 +1,7 @@
 package neomega_backbone
 
+import "context"
+
 type DynamicComponentConfig interface {
 	Upgrade(any) error
 	Configs() any
@@ -17,6 +19,50 @@ type DynamicComponent interface {
 	// Stop() error
 }
 
+// StoppableDynamicComponent 是可以被停止和重载的组件, 见 component_host.Host
+// ActivateWithContext 代替 Activate 被调用, ctx 在组件被停止或重载时取消, 组件应当在此后尽快返回
+// Dispose 在 ActivateWithContext 返回(或等待超时)后调用, 用于释放组件持有的其他资源
+// 通过 ExtendOmega 注册的菜单项, soft api 和监听由 Host 负责注销, 不需要在 Dispose 中处理
+type StoppableDynamicComponent interface {
+	DynamicComponent
+	ActivateWithContext(ctx context.Context)
+	Dispose() error
+}
+
+type CapabilityKind string
+
+const (
+	// 通过 FlexEnhance.RegSoftAPI 注册的 api
+	CapabilitySoftAPI CapabilityKind = "soft_api"
+	// 通过 FlexEnhance.SoftPublish/InProcessPublish 发布的 topic
+	CapabilityTopic CapabilityKind = "topic"
+	// 其他具名服务, 含义由提供者和使用者约定
+	CapabilityService CapabilityKind = "service"
+)
+
+type Capability struct {
+	Kind CapabilityKind
+	Name string
+	// 仅用于 Requires, 没有提供者时不视为错误, 但若有提供者, 仍然保证其先于使用者启动
+	Optional bool
+}
+
+func (c Capability) String() string {
+	return string(c.Kind) + ":" + c.Name
+}
+
+// HasDependencies 是组件可选实现的接口, 用于声明组件提供和依赖的能力
+// Host 据此决定 BeforeActivate/Activate 的顺序: 提供者总是先于依赖它的组件
+// 顺序只保证提供者的 BeforeActivate 先返回, Activate 在各自的 goroutine 中并发运行,
+// 因此提供者需要在 Inject 或 BeforeActivate 中完成 api 和 topic 的注册, 在 Activate 中注册的能力不保证在依赖者启动时可用
+// 单独启动, 重载或崩溃后重启组件时, 其依赖的能力必须已经由已激活(且未崩溃)的组件或框架提供, 否则启动失败(重启时等到有新的能力可用后再重试)
+// 在 Init 之后被调用, 因此可以根据配置决定
+type HasDependencies interface {
+	Provides() []Capability
+	Requires() []Capability
+}
+
+// ChallengeFn 由框架交给组件的 factory, 组件通过它向框架证明自己的来源, 协议见 component_auth
 type ChallengeFn func(challenge string) (response string)
 type DynamicComponentFactory func(name string, fn ChallengeFn) DynamicComponent
 
@@ -0,0 +1,61 @@
+package file_log_kvdb
+
+import (
+	"errors"
+	"strconv"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+var ErrBatchDone = errors.New("file_log_kvdb: batch already committed or discarded")
+
+var _ neomega_backbone.KVDBLikeWithBatch = (*FileLogKVDB)(nil)
+
+// Batch 的所有写操作与一条 commit 记录通过一次 Write 追加到日志,
+// 重放时只有读到 commit 记录的 batch 才会生效
+type Batch struct {
+	db   *FileLogKVDB
+	ops  []record
+	done bool
+}
+
+func (db *FileLogKVDB) NewBatch() neomega_backbone.KVDBBatch {
+	return &Batch{db: db}
+}
+
+func (b *Batch) Set(key string, value string) {
+	b.ops = append(b.ops, record{op: opSet, key: key, value: value})
+}
+
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, record{op: opDelete, key: key})
+}
+
+func (b *Batch) Commit() error {
+	if b.done {
+		return ErrBatchDone
+	}
+	b.done = true
+	if len(b.ops) == 0 {
+		return nil
+	}
+	var buf []byte
+	for _, r := range b.ops {
+		op := opBatchSet
+		if r.op == opDelete {
+			op = opBatchDelete
+		}
+		buf = append(buf, encodeRecord(record{op: op, key: r.key, value: r.value})...)
+	}
+	buf = append(buf, encodeRecord(record{op: opCommit, value: strconv.Itoa(len(b.ops))})...)
+
+	db := b.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.appendAndApply(buf, b.ops...)
+}
+
+func (b *Batch) Discard() {
+	b.done = true
+	b.ops = nil
+}
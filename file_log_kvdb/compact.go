@@ -0,0 +1,155 @@
+package file_log_kvdb
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+func (db *FileLogKVDB) wakeCompactor() {
+	select {
+	case db.compactWake <- struct{}{}:
+	default:
+	}
+}
+
+// needCompact 调用者需要持有锁
+func (db *FileLogKVDB) needCompact() bool {
+	garbage := db.fileSize - db.liveSize
+	if garbage <= 0 || garbage < db.opts.CompactMinGarbageSize {
+		return false
+	}
+	if db.opts.CompactGarbageRatio > 0 && float64(garbage) >= float64(db.fileSize)*db.opts.CompactGarbageRatio {
+		return true
+	}
+	if db.opts.MaxFileSize > 0 && db.fileSize > db.opts.MaxFileSize {
+		return true
+	}
+	return false
+}
+
+func (db *FileLogKVDB) background() {
+	defer db.backgroundWg.Done()
+	var compactTick, sweepTick <-chan time.Time
+	if db.opts.CompactCheckInterval > 0 {
+		ticker := time.NewTicker(db.opts.CompactCheckInterval)
+		defer ticker.Stop()
+		compactTick = ticker.C
+	}
+	if db.opts.TTLSweepInterval > 0 {
+		ticker := time.NewTicker(db.opts.TTLSweepInterval)
+		defer ticker.Stop()
+		sweepTick = ticker.C
+	}
+	for {
+		select {
+		case <-db.closeCh:
+			return
+		case <-sweepTick:
+			db.mu.Lock()
+			db.dropExpired(time.Now().UnixNano())
+			db.mu.Unlock()
+		case <-db.compactWake:
+		case <-compactTick:
+		}
+		// 只有 I/O 错误需要通过压缩修复, WriteGuard 拒绝的写入不会改变日志
+		db.mu.RLock()
+		need := db.needCompact() || db.ioErr != nil
+		db.mu.RUnlock()
+		if need {
+			db.Compact()
+		}
+	}
+}
+
+// Compact 将存活的数据重写到新日志中, 然后通过 rename 替换旧日志
+// 重写期间 Get/Iter/Set/Delete 均可正常使用, 期间的写入会在替换前补写到新日志
+func (db *FileLogKVDB) Compact() error {
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return ErrClosed
+	}
+	snapshot := make(map[string]string, len(db.data))
+	for k, v := range db.data {
+		snapshot[k] = v
+	}
+	snapshotExpires := make(map[string]int64, len(db.expires))
+	for k, v := range db.expires {
+		snapshotExpires[k] = v
+	}
+	db.compacting = true
+	db.pending = nil
+	db.mu.Unlock()
+
+	tmpPath := db.path(tmpFileName)
+	fp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	var size int64
+	if err == nil {
+		size, err = writeSnapshot(fp, snapshot, snapshotExpires)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	pending := db.pending
+	db.compacting = false
+	db.pending = nil
+	if fp == nil {
+		db.setErr(err, false)
+		return err
+	}
+	for _, b := range pending {
+		if err != nil {
+			break
+		}
+		var n int
+		n, err = fp.Write(b)
+		size += int64(n)
+	}
+	if err == nil {
+		err = fp.Sync()
+	}
+	if closeErr := fp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		db.setErr(err, false)
+		return err
+	}
+	// windows 上无法 rename 覆盖仍被打开的文件, 因此先关闭写入句柄, 之后无论成功与否都重新打开
+	if db.file != nil {
+		db.file.Close()
+		db.file = nil
+	}
+	if err := os.Rename(tmpPath, db.path(logFileName)); err != nil {
+		os.Remove(tmpPath)
+		db.setErr(err, false)
+		db.reopen()
+		return err
+	}
+	if err := db.reopen(); err != nil {
+		db.setErr(err, false)
+		return err
+	}
+	db.fileSize = size
+	db.ioErr = nil
+	if !db.errFromGuard {
+		db.err = nil
+	}
+	return nil
+}
+
+// reopen 重新打开日志的写入句柄, 失败时设置 ioErr, 调用者需要持有写锁
+func (db *FileLogKVDB) reopen() error {
+	fp, err := os.OpenFile(db.path(logFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		db.ioErr = fmt.Errorf("file_log_kvdb: reopen log: %w", err)
+		return db.ioErr
+	}
+	db.file = fp
+	return nil
+}
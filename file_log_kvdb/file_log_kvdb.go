@@ -0,0 +1,437 @@
+package file_log_kvdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+const (
+	// DBType 是 StorageAndPathAccess.GetKVDBLike 中用于选择该实现的 dbType
+	DBType = "FileLogKVDBLike"
+
+	logFileName = "data.log"
+	tmpFileName = "data.log.tmp"
+)
+
+var ErrClosed = errors.New("file_log_kvdb: db closed")
+
+// FileLogKVDB 是一个只追加写入的 KVDBLike 实现
+// 所有数据常驻内存, 每次修改都以一条记录追加到日志文件末尾, 打开时重放日志恢复数据
+// 由于从不 seek, 可以工作在 android 公共目录这类不支持 seek 的文件系统上
+// 被覆盖或删除的记录会在后台压缩时被清理, 见 Options
+type FileLogKVDB struct {
+	mu   sync.RWMutex
+	opts Options
+	dir  string
+	file *os.File
+	data map[string]string
+	// 设置了 TTL 的 key 的过期时间(unix 纳秒)
+	expires map[string]int64
+	// 按字典序排列的所有 key, 重放日志期间为 nil, 重放结束后一次性建立
+	keys     sortedKeys
+	fileSize int64
+	// 存活记录在压缩后的日志中所占的字节数
+	liveSize int64
+	// 最近一次写入失败的原因, 见 Err
+	err          error
+	errFromGuard bool
+	// 日志文件处于无法继续追加的状态(末尾有无法回滚的半条记录, 或写入句柄丢失), 压缩成功后清除
+	ioErr  error
+	closed bool
+
+	// 压缩进行中时, 新写入的记录会同时暂存在这里, 压缩结束时追加到新日志末尾
+	compacting   bool
+	pending      [][]byte
+	compactMu    sync.Mutex
+	compactWake  chan struct{}
+	closeCh      chan struct{}
+	backgroundWg sync.WaitGroup
+}
+
+var (
+	_ neomega_backbone.KVDBLike        = (*FileLogKVDB)(nil)
+	_ neomega_backbone.KVDBLikeWithErr = (*FileLogKVDB)(nil)
+)
+
+// NewFileLogKVDB 打开(或创建) saveDir 下的数据库
+// 若日志最后一条记录不完整(进程在写入时被杀死), 该记录以及未提交的 batch 会被丢弃, 日志会被重写为完整的形式
+// 若损坏的记录之后还有有效记录, 返回 CorruptedError, 日志保持不变
+func NewFileLogKVDB(saveDir string) (*FileLogKVDB, error) {
+	return NewFileLogKVDBWithOptions(saveDir, DefaultOptions())
+}
+
+func NewFileLogKVDBWithOptions(saveDir string, opts Options) (*FileLogKVDB, error) {
+	if err := os.MkdirAll(saveDir, 0755); err != nil {
+		return nil, err
+	}
+	db := &FileLogKVDB{
+		opts:        opts,
+		dir:         saveDir,
+		data:        map[string]string{},
+		expires:     map[string]int64{},
+		liveSize:    int64(len(fileHeader)),
+		compactWake: make(chan struct{}, 1),
+		closeCh:     make(chan struct{}),
+	}
+	// 上次重写时残留的临时文件, 说明重写未完成, 原日志仍然有效
+	os.Remove(db.path(tmpFileName))
+	needRewrite, err := db.replay()
+	if err != nil {
+		return nil, err
+	}
+	db.dropExpired(time.Now().UnixNano())
+	if needRewrite {
+		if err := db.rewrite(); err != nil {
+			return nil, err
+		}
+	}
+	db.rebuildIndex()
+	db.file, err = os.OpenFile(db.path(logFileName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if db.fileSize == 0 {
+		if err := db.appendBytes([]byte(fileHeader)); err != nil {
+			db.file.Close()
+			return nil, err
+		}
+	}
+	db.backgroundWg.Add(1)
+	go db.background()
+	return db, nil
+}
+
+func (db *FileLogKVDB) path(name string) string {
+	return filepath.Join(db.dir, name)
+}
+
+// replay 读取整个日志并在内存中重建数据
+func (db *FileLogKVDB) replay() (needRewrite bool, err error) {
+	fp, err := os.Open(db.path(logFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer fp.Close()
+	header := make([]byte, len(fileHeader))
+	if n, err := io.ReadFull(fp, header); err != nil {
+		if n == 0 && err == io.EOF {
+			return false, nil
+		}
+		// 文件头都未能写完
+		return true, nil
+	}
+	if string(header) != fileHeader {
+		return false, fmt.Errorf("file_log_kvdb: %v is not a valid kvdb log", db.path(logFileName))
+	}
+	stat, err := fp.Stat()
+	if err != nil {
+		return false, err
+	}
+	rr := newRecordReader(fp, stat.Size()-int64(len(fileHeader)))
+	// 尚未遇到 commit 的 batch 写操作, 若直到日志末尾都没有 commit, 则被丢弃
+	var batch []record
+	for {
+		r, err := rr.next()
+		if err == io.EOF {
+			break
+		}
+		if err == errTruncated {
+			if err := db.checkTornTail(int64(len(fileHeader)) + rr.offset); err != nil {
+				return false, err
+			}
+			needRewrite = true
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		switch r.op {
+		case opBatchSet:
+			batch = append(batch, record{op: opSet, key: r.key, value: r.value})
+		case opBatchDelete:
+			batch = append(batch, record{op: opDelete, key: r.key})
+		case opCommit:
+			if r.value == strconv.Itoa(len(batch)) {
+				for _, br := range batch {
+					db.apply(br)
+				}
+			}
+			batch = nil
+		default:
+			batch = nil
+			db.apply(r)
+		}
+	}
+	if len(batch) > 0 {
+		// 未提交的 batch 需要从日志中清除, 否则之后追加的 batch 会与其混在一起
+		needRewrite = true
+	}
+	db.fileSize = int64(len(fileHeader)) + rr.offset
+	return needRewrite, nil
+}
+
+// checkTornTail 确认 offset 处的损坏记录之后没有有效记录, 否则截断日志会丢失数据
+func (db *FileLogKVDB) checkTornTail(offset int64) error {
+	fp, err := os.Open(db.path(logFileName))
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	// 不使用 seek, 见 FileLogKVDB
+	if _, err := io.CopyN(io.Discard, fp, offset+1); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	rest, err := io.ReadAll(fp)
+	if err != nil {
+		return err
+	}
+	if hasValidRecord(rest) {
+		return &CorruptedError{Path: db.path(logFileName), Offset: offset}
+	}
+	return nil
+}
+
+func (db *FileLogKVDB) apply(r record) {
+	switch r.op {
+	case opSet, opSetExpire:
+		db.put(r.key, r.value, r.expire)
+	case opDelete:
+		db.remove(r.key)
+	}
+}
+
+// put 和 remove 只修改内存中的数据, expire 为 0 表示没有过期时间
+func (db *FileLogKVDB) put(key, value string, expire int64) {
+	if old, ok := db.data[key]; ok {
+		db.liveSize -= recordSize(key, old, db.expires[key])
+	} else if db.keys != nil {
+		db.keys.insert(key)
+	}
+	db.data[key] = value
+	if expire != 0 {
+		db.expires[key] = expire
+	} else {
+		delete(db.expires, key)
+	}
+	db.liveSize += recordSize(key, value, expire)
+}
+
+func (db *FileLogKVDB) remove(key string) {
+	old, ok := db.data[key]
+	if !ok {
+		return
+	}
+	db.liveSize -= recordSize(key, old, db.expires[key])
+	delete(db.data, key)
+	delete(db.expires, key)
+	if db.keys != nil {
+		db.keys.remove(key)
+	}
+}
+
+// rewrite 将当前内存中的数据写入临时文件, 然后通过 rename 替换原日志
+// 调用时 db.file 必须未打开或已关闭
+func (db *FileLogKVDB) rewrite() error {
+	tmpPath := db.path(tmpFileName)
+	fp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	size, err := writeSnapshot(fp, db.data, db.expires)
+	if err == nil {
+		err = fp.Sync()
+	}
+	if closeErr := fp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, db.path(logFileName)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	db.fileSize = size
+	return nil
+}
+
+func writeSnapshot(w io.Writer, data map[string]string, expires map[string]int64) (size int64, err error) {
+	buf := []byte(fileHeader)
+	flush := func() error {
+		n, err := w.Write(buf)
+		size += int64(n)
+		buf = buf[:0]
+		return err
+	}
+	for k, v := range data {
+		r := record{op: opSet, key: k, value: v}
+		if expire, ok := expires[k]; ok {
+			r.op, r.expire = opSetExpire, expire
+		}
+		buf = append(buf, encodeRecord(r)...)
+		if len(buf) > 1<<16 {
+			if err := flush(); err != nil {
+				return size, err
+			}
+		}
+	}
+	return size, flush()
+}
+
+// appendBytes 以一次 Write 调用追加数据, 调用者需要持有写锁
+// 写入失败时将日志截断回写入前的长度, 使之后的记录不会跟在半条记录后面(重放时会被当作损坏的末尾丢弃)
+// 截断也失败时设置 ioErr, 此后的追加都会被拒绝, 直到压缩从内存重写整个日志
+func (db *FileLogKVDB) appendBytes(b []byte) error {
+	if db.closed {
+		return ErrClosed
+	}
+	if db.ioErr != nil {
+		return db.ioErr
+	}
+	n, err := db.file.Write(b)
+	if err == nil {
+		db.fileSize += int64(n)
+		return nil
+	}
+	if n > 0 {
+		if truncErr := db.file.Truncate(db.fileSize); truncErr != nil {
+			db.ioErr = fmt.Errorf("file_log_kvdb: partial write can not be rolled back (%v): %w", truncErr, err)
+			db.wakeCompactor()
+			return db.ioErr
+		}
+	}
+	return err
+}
+
+// setErr 记录写入失败的原因, 见 Err, 调用者需要持有写锁
+func (db *FileLogKVDB) setErr(err error, fromGuard bool) {
+	db.err = err
+	db.errFromGuard = fromGuard
+}
+
+// onlyDeletes 判断 rs 是否只包含删除
+func onlyDeletes(rs []record) bool {
+	for _, r := range rs {
+		if r.op != opDelete {
+			return false
+		}
+	}
+	return len(rs) > 0
+}
+
+// appendAndApply 追加已编码的记录 b, 写入成功后在内存中应用 rs, 调用者需要持有写锁
+func (db *FileLogKVDB) appendAndApply(b []byte, rs ...record) error {
+	if db.opts.WriteGuard != nil && !onlyDeletes(rs) {
+		if err := db.opts.WriteGuard(int64(len(b))); err != nil {
+			db.setErr(err, true)
+			return err
+		}
+	}
+	if err := db.appendBytes(b); err != nil {
+		db.setErr(err, false)
+		return err
+	}
+	for _, r := range rs {
+		db.apply(r)
+	}
+	if db.compacting {
+		db.pending = append(db.pending, b)
+	} else if db.needCompact() {
+		db.wakeCompactor()
+	}
+	return nil
+}
+
+func (db *FileLogKVDB) appendRecord(r record) {
+	db.appendAndApply(encodeRecord(r), r)
+}
+
+func (db *FileLogKVDB) Get(key string) (value string) {
+	db.mu.RLock()
+	value = db.data[key]
+	expire, hasTTL := db.expires[key]
+	db.mu.RUnlock()
+	if hasTTL && expire <= time.Now().UnixNano() {
+		db.expireKey(key)
+		return ""
+	}
+	return value
+}
+
+func (db *FileLogKVDB) Set(key string, value string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.appendRecord(record{op: opSet, key: key, value: value})
+}
+
+func (db *FileLogKVDB) Delete(key string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.data[key]; !ok {
+		return
+	}
+	db.appendRecord(record{op: opDelete, key: key})
+}
+
+// Iter 按 key 的字典序遍历, fn 在锁外调用, 因此可以在其中修改数据库(e.g. 删除遍历到的 key)
+func (db *FileLogKVDB) Iter(fn func(key, value string) bool) {
+	db.iterIndex("", func(string) bool { return false }, fn)
+}
+
+// Err 返回最近一次写入失败的错误, 见 KVDBLikeWithErr
+func (db *FileLogKVDB) Err() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.err
+}
+
+// Sync 将已写入的数据刷到磁盘
+func (db *FileLogKVDB) Sync() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	if db.ioErr != nil {
+		return db.ioErr
+	}
+	return db.file.Sync()
+}
+
+// Close 会等待正在进行的压缩结束
+func (db *FileLogKVDB) Close() error {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil
+	}
+	db.closed = true
+	close(db.closeCh)
+	db.mu.Unlock()
+	db.backgroundWg.Wait()
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return nil
+	}
+	err := db.file.Close()
+	db.file = nil
+	return err
+}
@@ -0,0 +1,139 @@
+package file_log_kvdb
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+var _ neomega_backbone.KVDBLikeWithOrderedIter = (*FileLogKVDB)(nil)
+
+// sortedKeys 是按字典序排列的 key 列表, 用于有序遍历
+// 插入和删除是 O(n) 的, 但对于插件数据的规模来说足够了
+type sortedKeys []string
+
+func (s sortedKeys) search(key string) int {
+	return sort.SearchStrings(s, key)
+}
+
+func (s *sortedKeys) insert(key string) {
+	i := s.search(key)
+	if i < len(*s) && (*s)[i] == key {
+		return
+	}
+	*s = append(*s, "")
+	copy((*s)[i+1:], (*s)[i:])
+	(*s)[i] = key
+}
+
+func (s *sortedKeys) remove(key string) {
+	i := s.search(key)
+	if i < len(*s) && (*s)[i] == key {
+		*s = append((*s)[:i], (*s)[i+1:]...)
+	}
+}
+
+// rebuildIndex 在重放日志后一次性建立索引, 调用者需要持有写锁
+func (db *FileLogKVDB) rebuildIndex() {
+	keys := make(sortedKeys, 0, len(db.data))
+	for k := range db.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	db.keys = keys
+}
+
+// iterBatch 是 iterIndex 每次在读锁内取出的项数
+const iterBatch = 256
+
+type kvPair struct {
+	key, value string
+}
+
+// iterIndex 从 start 开始按顺序遍历, 直到 stop 返回 true 或 fn 返回 false, 调用者不能持有锁
+// 每次在读锁内取出最多 iterBatch 项, 释放锁后再调用 fn, 因此 fn 中可以读写数据库
+// 遍历期间被修改的 key 是否被遍历到取决于它与当前位置的先后, 已过期但尚未被清理的 key 会被跳过
+func (db *FileLogKVDB) iterIndex(start string, stop func(key string) bool, fn func(key, value string) bool) {
+	after := false
+	for {
+		batch, more := db.collect(start, after, stop)
+		for _, kv := range batch {
+			if !fn(kv.key, kv.value) {
+				return
+			}
+		}
+		if !more {
+			return
+		}
+		start, after = batch[len(batch)-1].key, true
+	}
+}
+
+// collect 取出从 start(after 为 true 时不包括 start 本身) 开始的最多 iterBatch 项, more 表示之后可能还有
+func (db *FileLogKVDB) collect(start string, after bool, stop func(key string) bool) (batch []kvPair, more bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	now := time.Now().UnixNano()
+	i := db.keys.search(start)
+	if after && i < len(db.keys) && db.keys[i] == start {
+		i++
+	}
+	for ; i < len(db.keys); i++ {
+		k := db.keys[i]
+		if stop(k) {
+			return batch, false
+		}
+		if db.isExpired(k, now) {
+			continue
+		}
+		if len(batch) == iterBatch {
+			return batch, true
+		}
+		batch = append(batch, kvPair{k, db.data[k]})
+	}
+	return batch, false
+}
+
+func (db *FileLogKVDB) IterPrefix(prefix string, fn func(key, value string) bool) {
+	db.iterIndex(prefix, func(key string) bool {
+		return !strings.HasPrefix(key, prefix)
+	}, fn)
+}
+
+func (db *FileLogKVDB) IterRange(start, end string, fn func(key, value string) bool) {
+	db.iterIndex(start, func(key string) bool {
+		return end != "" && key >= end
+	}, fn)
+}
+
+func (db *FileLogKVDB) Count() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	now := time.Now().UnixNano()
+	count := len(db.data)
+	for _, expire := range db.expires {
+		if expire <= now {
+			count--
+		}
+	}
+	return count
+}
+
+func (db *FileLogKVDB) CountPrefix(prefix string) int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	start := db.keys.search(prefix)
+	end := start + sort.Search(len(db.keys)-start, func(i int) bool {
+		return !strings.HasPrefix(db.keys[start+i], prefix)
+	})
+	now := time.Now().UnixNano()
+	count := end - start
+	for k, expire := range db.expires {
+		if expire <= now && strings.HasPrefix(k, prefix) {
+			count--
+		}
+	}
+	return count
+}
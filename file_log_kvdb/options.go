@@ -0,0 +1,31 @@
+package file_log_kvdb
+
+import "time"
+
+type Options struct {
+	// 垃圾(被覆盖或删除的记录)占整个日志的比例超过该值时触发压缩, <=0 表示不按比例压缩
+	CompactGarbageRatio float64
+	// 垃圾字节数小于该值时不触发压缩, 避免小文件被频繁重写
+	CompactMinGarbageSize int64
+	// 日志文件超过该大小时触发压缩, <=0 表示不限制
+	// 若存活数据本身就超过该大小, 压缩只能回收垃圾部分
+	MaxFileSize int64
+	// 后台检查是否需要压缩的间隔, <=0 表示仅在写入后检查
+	CompactCheckInterval time.Duration
+	// 清理已过期 key 的间隔, <=0 表示只在访问时清理
+	TTLSweepInterval time.Duration
+	// 每次追加 n 字节到日志之前调用(压缩和只包含删除的写入除外), 返回 error 时拒绝此次写入, 可用于实现磁盘配额
+	// 删除不受限制, 以便超出配额后仍然可以通过删除 key 回到配额之内
+	// 被拒绝的 Set/Delete 的错误可以通过 Err 获得
+	WriteGuard func(n int64) error
+}
+
+func DefaultOptions() Options {
+	return Options{
+		CompactGarbageRatio:   0.5,
+		CompactMinGarbageSize: 1 << 20,
+		MaxFileSize:           64 << 20,
+		CompactCheckInterval:  time.Minute,
+		TTLSweepInterval:      time.Minute,
+	}
+}
@@ -0,0 +1,207 @@
+package file_log_kvdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// 日志文件由文件头和一系列记录组成, 每条记录格式如下:
+// [op 1byte][len(key) uvarint][len(value) uvarint][key][value][crc32(前面所有字节) 4byte]
+// 记录只会被追加, 因此不需要 seek
+
+const fileHeader = "FLKV1\n"
+
+const (
+	opSet    byte = 'S'
+	opDelete byte = 'D'
+	// batch 中的写操作, 只有遇到其后的 opCommit 才会生效
+	opBatchSet    byte = 's'
+	opBatchDelete byte = 'd'
+	// value 为该 batch 中写操作的数量
+	opCommit byte = 'C'
+	// 带过期时间的 set, value 的前 8 字节为过期时间(unix 纳秒, 大端序)
+	opSetExpire byte = 'E'
+)
+
+const maxRecordFieldLen = 1 << 30
+
+// errTruncated 表示读到了一条未写完或已损坏的记录, 若其后没有任何有效记录, 说明是进程在写入时被直接杀死造成的
+var errTruncated = errors.New("file_log_kvdb: truncated or corrupted record")
+
+// ErrCorrupted 表示日志中间有损坏的记录, 其后仍有有效记录, 此时无法安全地截断日志
+var ErrCorrupted = errors.New("file_log_kvdb: log corrupted")
+
+type CorruptedError struct {
+	Path   string
+	Offset int64
+}
+
+func (e *CorruptedError) Error() string {
+	return fmt.Sprintf("file_log_kvdb: %v is corrupted at offset %v, but valid records follow it", e.Path, e.Offset)
+}
+
+func (e *CorruptedError) Unwrap() error {
+	return ErrCorrupted
+}
+
+type record struct {
+	op    byte
+	key   string
+	value string
+	// 仅 opSetExpire 使用
+	expire int64
+}
+
+func encodeRecord(r record) []byte {
+	valueLen := len(r.value)
+	if r.op == opSetExpire {
+		valueLen += 8
+	}
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64*2+len(r.key)+valueLen+4)
+	buf = append(buf, r.op)
+	buf = binary.AppendUvarint(buf, uint64(len(r.key)))
+	buf = binary.AppendUvarint(buf, uint64(valueLen))
+	buf = append(buf, r.key...)
+	if r.op == opSetExpire {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(r.expire))
+	}
+	buf = append(buf, r.value...)
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+// recordSize 返回一条 set 记录编码后的长度, expire 不为 0 时为 opSetExpire 记录的长度
+func recordSize(key, value string, expire int64) int64 {
+	valueLen := len(value)
+	if expire != 0 {
+		valueLen += 8
+	}
+	return int64(1 + uvarintLen(len(key)) + uvarintLen(valueLen) + len(key) + valueLen + 4)
+}
+
+func uvarintLen(x int) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+// recordReader 顺序读取记录, 并记录已经成功读取的字节数
+type recordReader struct {
+	r      *bufio.Reader
+	crc    uint32
+	offset int64
+	read   int64
+	// r 中的总字节数, 用于在分配内存前检查记录头中的长度
+	size int64
+}
+
+func newRecordReader(r io.Reader, size int64) *recordReader {
+	return &recordReader{r: bufio.NewReader(r), size: size}
+}
+
+func (rr *recordReader) ReadByte() (byte, error) {
+	b, err := rr.r.ReadByte()
+	if err == nil {
+		rr.crc = crc32.Update(rr.crc, crc32.IEEETable, []byte{b})
+		rr.read++
+	}
+	return b, err
+}
+
+func (rr *recordReader) readFull(n uint64) ([]byte, error) {
+	// 损坏的记录头可能声明任意长度, 超出剩余字节数的记录必然不完整
+	if n > maxRecordFieldLen || int64(n) > rr.size-rr.offset-rr.read {
+		return nil, errTruncated
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rr.r, buf); err != nil {
+		return nil, err
+	}
+	rr.crc = crc32.Update(rr.crc, crc32.IEEETable, buf)
+	rr.read += int64(n)
+	return buf, nil
+}
+
+// next 返回下一条记录, 读到干净的文件末尾时返回 io.EOF,
+// 读到不完整或校验失败的记录时返回 errTruncated
+func (rr *recordReader) next() (record, error) {
+	rr.crc = 0
+	rr.read = 0
+	op, err := rr.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			return record{}, io.EOF
+		}
+		return record{}, err
+	}
+	if !isKnownOp(op) {
+		return record{}, errTruncated
+	}
+	keyLen, err := binary.ReadUvarint(rr)
+	if err != nil {
+		return record{}, asTruncated(err)
+	}
+	valueLen, err := binary.ReadUvarint(rr)
+	if err != nil {
+		return record{}, asTruncated(err)
+	}
+	key, err := rr.readFull(keyLen)
+	if err != nil {
+		return record{}, asTruncated(err)
+	}
+	value, err := rr.readFull(valueLen)
+	if err != nil {
+		return record{}, asTruncated(err)
+	}
+	expect := rr.crc
+	var sum [4]byte
+	if _, err := io.ReadFull(rr.r, sum[:]); err != nil {
+		return record{}, asTruncated(err)
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != expect {
+		return record{}, errTruncated
+	}
+	if op == opSetExpire && len(value) < 8 {
+		return record{}, errTruncated
+	}
+	rr.offset += rr.read + 4
+	if op == opSetExpire {
+		return record{op: op, key: string(key), value: string(value[8:]), expire: int64(binary.BigEndian.Uint64(value))}, nil
+	}
+	return record{op: op, key: string(key), value: string(value)}, nil
+}
+
+// hasValidRecord 检查 b 中是否在某个位置开始有一条完整且校验通过的记录
+func hasValidRecord(b []byte) bool {
+	for i := range b {
+		if !isKnownOp(b[i]) {
+			continue
+		}
+		if _, err := newRecordReader(bytes.NewReader(b[i:]), int64(len(b)-i)).next(); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func isKnownOp(op byte) bool {
+	switch op {
+	case opSet, opDelete, opBatchSet, opBatchDelete, opCommit, opSetExpire:
+		return true
+	}
+	return false
+}
+
+func asTruncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == errTruncated {
+		return errTruncated
+	}
+	return err
+}
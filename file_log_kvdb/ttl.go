@@ -0,0 +1,55 @@
+package file_log_kvdb
+
+import (
+	"time"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+var _ neomega_backbone.KVDBLikeWithTTL = (*FileLogKVDB)(nil)
+
+// 过期时间以绝对时间保存在日志中, 因此过期的 key 只需从内存中移除, 不需要追加删除记录:
+// 重放时它们依然是过期的, 会在打开时被丢弃, 并在下一次压缩时从日志中清除
+
+func (db *FileLogKVDB) SetWithTTL(key string, value string, ttl time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.appendRecord(record{op: opSetExpire, key: key, value: value, expire: time.Now().Add(ttl).UnixNano()})
+}
+
+func (db *FileLogKVDB) TTL(key string) (ttl time.Duration, hasTTL bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	expire, ok := db.expires[key]
+	if !ok {
+		return 0, false
+	}
+	ttl = time.Until(time.Unix(0, expire))
+	if ttl <= 0 {
+		return 0, false
+	}
+	return ttl, true
+}
+
+// isExpired 调用者需要持有锁
+func (db *FileLogKVDB) isExpired(key string, now int64) bool {
+	expire, ok := db.expires[key]
+	return ok && expire <= now
+}
+
+func (db *FileLogKVDB) expireKey(key string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.isExpired(key, time.Now().UnixNano()) {
+		db.remove(key)
+	}
+}
+
+// dropExpired 调用者需要持有写锁
+func (db *FileLogKVDB) dropExpired(now int64) {
+	for key, expire := range db.expires {
+		if expire <= now {
+			db.remove(key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+package file_watcher
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+type inotifyBackend struct {
+	fd       int
+	file     *os.File
+	onEvent  func(id int, name string)
+	onGone   func(id int)
+	onBroken func()
+}
+
+func newNativeBackend(onEvent func(id int, name string), onGone func(id int), onBroken func()) (nativeBackend, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// 非阻塞的 fd 会被注册到 go 的 poller 中, 因此 Close 可以打断正在进行的 Read
+	b := &inotifyBackend{fd: fd, file: os.NewFile(uintptr(fd), "inotify"), onEvent: onEvent, onGone: onGone, onBroken: onBroken}
+	go b.readLoop()
+	return b, nil
+}
+
+func (b *inotifyBackend) addDir(dir string) (int, error) {
+	// 同一个目录重复添加时, inotify 返回相同的 wd
+	return syscall.InotifyAddWatch(b.fd, dir, inotifyMask)
+}
+
+func (b *inotifyBackend) removeDir(id int) {
+	syscall.InotifyRmWatch(b.fd, uint32(id))
+}
+
+func (b *inotifyBackend) close() error {
+	return b.file.Close()
+}
+
+func (b *inotifyBackend) readLoop() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := b.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				b.onBroken()
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(buf[nameStart:nameEnd])
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			switch {
+			case event.Mask&syscall.IN_Q_OVERFLOW != 0:
+				b.onEvent(overflowID, "")
+			case event.Mask&syscall.IN_IGNORED != 0:
+				// 目录被删除, 被移走或 watch 被移除
+				b.onGone(int(event.Wd))
+			case event.Mask&syscall.IN_MOVE_SELF != 0:
+				// 被移走的目录仍然被监听, 但原路径已经不是它了, 移除 watch 后会收到 IN_IGNORED
+				b.removeDir(int(event.Wd))
+			case event.Mask&syscall.IN_DELETE_SELF != 0:
+				// 之后会收到 IN_IGNORED
+			default:
+				b.onEvent(int(event.Wd), name)
+			}
+			offset = nameEnd
+		}
+	}
+}
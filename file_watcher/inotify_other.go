@@ -0,0 +1,9 @@
+//go:build !linux
+
+package file_watcher
+
+import "errors"
+
+func newNativeBackend(onEvent func(id int, name string), onGone func(id int), onBroken func()) (nativeBackend, error) {
+	return nil, errors.New("file_watcher: native watching is not supported on this platform, fallback to polling")
+}
@@ -0,0 +1,318 @@
+package file_watcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("file_watcher: watcher closed")
+
+// overflowID 是 nativeBackend 在事件队列溢出(部分事件已丢失)时传给 onEvent 的 id
+const overflowID = -1
+
+// defaultPollInterval 是 NewWatcher 的 pollInterval 不为正数时使用的轮询间隔
+const defaultPollInterval = time.Second
+
+// nativeBackend 是操作系统提供的文件监听(linux 下为 inotify), 只需要支持监听目录中的直接子项
+// 事件队列溢出时以 overflowID 调用 onEvent, 被监听的目录被删除或移走(监听失效)时以其 id 调用 onGone,
+// 无法继续读取事件时调用 onBroken
+type nativeBackend interface {
+	addDir(dir string) (id int, err error)
+	removeDir(id int)
+	close() error
+}
+
+type subscription struct {
+	// 被监听的路径, 以及其所在(或本身就是)的目录
+	path  string
+	dir   string
+	isDir bool
+	cb    func(path string)
+	// native 监听失败时使用轮询
+	polling bool
+	// 因目录被删除或移走而改为轮询, 目录重新出现后恢复 native 监听
+	renative bool
+	lastStat map[string]fileStat
+	nativeID int
+	// debounce 期间发生变化的所有路径
+	pending map[string]bool
+	timer   *time.Timer
+	stopped bool
+}
+
+type fileStat struct {
+	size    int64
+	modTime time.Time
+}
+
+// Watcher 监听文件或目录的变化, 短时间内的多次变化会被合并为一次回调
+type Watcher struct {
+	debounce     time.Duration
+	pollInterval time.Duration
+
+	mu     sync.Mutex
+	native nativeBackend
+	// key 为 nativeBackend 返回的 id
+	nativeSubs map[int][]*subscription
+	pollSubs   map[*subscription]bool
+	closed     bool
+	closeCh    chan struct{}
+}
+
+// NewWatcher 优先使用操作系统的文件监听, 不可用(或之后失效)时退化为每 pollInterval 轮询一次
+// pollInterval 不为正数时使用 defaultPollInterval
+func NewWatcher(debounce time.Duration, pollInterval time.Duration) *Watcher {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	w := &Watcher{
+		debounce:     debounce,
+		pollInterval: pollInterval,
+		nativeSubs:   map[int][]*subscription{},
+		pollSubs:     map[*subscription]bool{},
+		closeCh:      make(chan struct{}),
+	}
+	if native, err := newNativeBackend(w.onNativeEvent, w.onNativeGone, w.onNativeBroken); err == nil {
+		w.native = native
+	}
+	go w.pollLoop()
+	return w
+}
+
+// Watch 在 path 发生变化时调用 cb, path 为目录时监听其直接子项的变化, 回调参数为发生变化的路径
+// debounce 时间内发生变化的每个路径各回调一次, 事件丢失时(e.g. inotify 队列溢出)以 path 本身回调
+// 被监听的目录被删除或替换(e.g. 恢复快照)时以 path 本身回调, 并在目录重新出现后继续监听
+// path 为文件时, 实际监听的是其所在目录, 因此编辑器以 "写入临时文件再 rename" 的方式保存时也能被发现
+func (w *Watcher) Watch(path string, cb func(path string)) (cancel func(), err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	sub := &subscription{path: path, dir: filepath.Dir(path), cb: cb}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		sub.isDir = true
+		sub.dir = path
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil, ErrClosed
+	}
+	if w.native != nil && w.addNative(sub) {
+		return func() { w.cancel(sub) }, nil
+	}
+	if _, err := os.Stat(sub.dir); err != nil {
+		return nil, err
+	}
+	w.startPolling(sub)
+	return func() { w.cancel(sub) }, nil
+}
+
+// startPolling 改为轮询 sub, 调用者需要持有锁
+func (w *Watcher) startPolling(sub *subscription) {
+	sub.polling = true
+	sub.lastStat = statAll(sub)
+	w.pollSubs[sub] = true
+}
+
+// addNative 为 sub 添加 native 监听, 调用者需要持有锁
+func (w *Watcher) addNative(sub *subscription) bool {
+	id, err := w.native.addDir(sub.dir)
+	if err != nil {
+		return false
+	}
+	sub.polling, sub.renative, sub.nativeID = false, false, id
+	w.nativeSubs[id] = append(w.nativeSubs[id], sub)
+	return true
+}
+
+func (w *Watcher) cancel(sub *subscription) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sub.stop()
+	if sub.polling {
+		delete(w.pollSubs, sub)
+		return
+	}
+	subs := w.nativeSubs[sub.nativeID]
+	for i, s := range subs {
+		if s == sub {
+			subs = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(subs) == 0 {
+		delete(w.nativeSubs, sub.nativeID)
+		if w.native != nil {
+			w.native.removeDir(sub.nativeID)
+		}
+	} else {
+		w.nativeSubs[sub.nativeID] = subs
+	}
+}
+
+func (sub *subscription) stop() {
+	sub.stopped = true
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+}
+
+// fire 记录 changed, 在 debounce 时间内没有新的变化后对记录的每个路径调用回调, 调用者需要持有锁
+func (w *Watcher) fire(sub *subscription, changed string) {
+	if sub.pending == nil {
+		sub.pending = map[string]bool{}
+	}
+	sub.pending[changed] = true
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	sub.timer = time.AfterFunc(w.debounce, func() {
+		w.mu.Lock()
+		if sub.stopped {
+			w.mu.Unlock()
+			return
+		}
+		changed := make([]string, 0, len(sub.pending))
+		for p := range sub.pending {
+			changed = append(changed, p)
+		}
+		sub.pending = nil
+		w.mu.Unlock()
+		sort.Strings(changed)
+		for _, p := range changed {
+			sub.cb(p)
+		}
+	})
+}
+
+func (w *Watcher) onNativeEvent(id int, name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if id == overflowID {
+		// 不知道哪些事件丢失了, 通知所有监听者
+		for _, subs := range w.nativeSubs {
+			for _, sub := range subs {
+				w.fire(sub, sub.path)
+			}
+		}
+		return
+	}
+	for _, sub := range w.nativeSubs[id] {
+		changed := filepath.Join(sub.dir, name)
+		if sub.isDir || changed == sub.path {
+			w.fire(sub, changed)
+		}
+	}
+}
+
+// onNativeGone 在目录 id 的 native 监听失效时将其监听者改为轮询, 直到目录重新出现
+func (w *Watcher) onNativeGone(id int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, sub := range w.nativeSubs[id] {
+		w.startPolling(sub)
+		sub.renative = true
+		w.fire(sub, sub.path)
+	}
+	delete(w.nativeSubs, id)
+}
+
+// onNativeBroken 在 nativeBackend 无法继续读取事件时将所有监听改为轮询, 并通知一次以免遗漏期间的变化
+func (w *Watcher) onNativeBroken() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.native == nil {
+		return
+	}
+	w.native.close()
+	w.native = nil
+	for _, subs := range w.nativeSubs {
+		for _, sub := range subs {
+			w.startPolling(sub)
+			w.fire(sub, sub.path)
+		}
+	}
+	w.nativeSubs = map[int][]*subscription{}
+}
+
+func statAll(sub *subscription) map[string]fileStat {
+	stats := map[string]fileStat{}
+	add := func(p string) {
+		if info, err := os.Stat(p); err == nil {
+			stats[p] = fileStat{size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+	if !sub.isDir {
+		add(sub.path)
+		return stats
+	}
+	// 目录本身只记录是否存在, 使目录被删除和重新出现时也会回调
+	if _, err := os.Stat(sub.path); err == nil {
+		stats[sub.path] = fileStat{}
+	}
+	entries, _ := os.ReadDir(sub.path)
+	for _, entry := range entries {
+		add(filepath.Join(sub.path, entry.Name()))
+	}
+	return stats
+}
+
+func (w *Watcher) pollLoop() {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closeCh:
+			return
+		case <-ticker.C:
+		}
+		w.mu.Lock()
+		for sub := range w.pollSubs {
+			stats := statAll(sub)
+			for p, stat := range stats {
+				if old, ok := sub.lastStat[p]; !ok || old != stat {
+					w.fire(sub, p)
+				}
+			}
+			for p := range sub.lastStat {
+				if _, ok := stats[p]; !ok {
+					w.fire(sub, p)
+				}
+			}
+			sub.lastStat = stats
+			if sub.renative && w.native != nil {
+				if _, err := os.Stat(sub.dir); err == nil && w.addNative(sub) {
+					delete(w.pollSubs, sub)
+				}
+			}
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.closeCh)
+	for _, subs := range w.nativeSubs {
+		for _, sub := range subs {
+			sub.stop()
+		}
+	}
+	for sub := range w.pollSubs {
+		sub.stop()
+	}
+	if w.native != nil {
+		return w.native.close()
+	}
+	return nil
+}
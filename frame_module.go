@@ -15,11 +15,17 @@ type HasLifeCycle interface {
 }
 
 type BasicConfig struct {
-	Name     string `json:"名称"`
-	Source   string `json:"来源"`
-	Disabled bool   `json:"是否禁用"`
+	Name     string   `json:"名称" description:"组件的名称"`
+	Source   string   `json:"来源" description:"组件的来源, e.g. 内置组件或某个插件"`
+	Disabled bool     `json:"是否禁用" description:"为 true 时不启动该组件"`
+	Tags     []string `json:"标签,omitempty" description:"组件的标签, 用于分类和筛选"`
+	// 由 config_upgrade.Upgrader 维护, 用于在组件更新后升级旧的配置文件
+	// 版本为 0(未注册过升级)时不写入配置文件, 因此已有的配置文件不会仅因为这个字段被重写
+	Version int `json:"配置版本,omitempty" description:"配置文件的版本, 请勿手动修改"`
 }
 
+// 组件的默认配置变化后, 可以通过 config_upgrade.Upgrader.SyncConfigFile 合并到已有的配置文件中
+// 配置文件可以是 json, json5, yaml 或 toml, 由扩展名决定, 读写见 config_format
 type ConfigWrite interface {
 	AddDefaultConfigFile(basicConfig *BasicConfig, fullConfig any, onWriteCallBack func(*BasicConfig, any))
 }
@@ -28,9 +34,29 @@ type ConfigRead interface {
 	GetEnabledConfigBySource(source string) []string
 }
 
+// ConfigQuery 中为空的条件不作限制, 所有条件都满足时才匹配, 见 config_registry.Match
+type ConfigQuery struct {
+	Source string
+	// NamePattern 为 path.Match 格式的通配符, e.g. "自动*"
+	NamePattern string
+	Tag         string
+}
+
+// ConfigRegistry 是 ConfigRead 的扩展, ConfigProvider 可以选择实现, 见 config_registry
+type ConfigRegistry interface {
+	ConfigRead
+	// QueryConfigs 按注册顺序返回所有匹配的配置(包括已禁用的)
+	QueryConfigs(query ConfigQuery) []BasicConfig
+	// SetConfigDisabled 启用或禁用组件, 并写回其配置文件
+	SetConfigDisabled(name string, disabled bool) error
+	// 组件被启用或禁用时调用 onChange, 无论是通过 SetConfigDisabled 还是用户修改了配置文件
+	SubscribeConfigState(onChange func(config BasicConfig)) (cancel func())
+}
+
 type ConfigProvider interface {
 	// GetFrameConfigOverride returns the frame config override, can be nil
 	// if not nil, it will be used to override/adjust the config
+	// config_override.Overrider implements this with env vars and --set flags
 	GetFrameConfigOverride(config any) any
 	ConfigRead
 	ConfigWrite
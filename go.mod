@@ -5,6 +5,9 @@ go 1.20
 require (
 	github.com/OmineDev/neomega-core v0.0.4
 	github.com/OmineDev/qq-bot-helper v0.0.2
+	github.com/pelletier/go-toml/v2 v2.2.2
+	github.com/ugorji/go/codec v1.2.12
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 //TODO: remove and bump version
@@ -20,6 +23,5 @@ require (
 	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
-	github.com/ugorji/go/codec v1.2.12 // indirect
 	golang.org/x/image v0.0.0-20190321063152-3fc05d484e9f // indirect
 )
@@ -85,3 +85,9 @@ type FlexEnhance interface {
 	InProcessCallAPI(apiName string, args any) async_wrapper.AsyncResult[any]
 	InProcessCallAPIOmitResponse(apiName string, args any)
 }
+
+// 可以注销 api 的 FlexEnhance, 组件被停止或重载时用于注销其注册的 api, 以便新实例重新注册
+type SoftAPIRemover interface {
+	UnregSoftAPI(cmd string)
+	UnregInProcessAPI(apiName string)
+}
@@ -27,6 +27,11 @@ type GameMenuSetter interface {
 	AddGameMenuEntry(*GameMenuEntry)
 }
 
+// 可以移除菜单项的 GameMenuSetter, 组件被停止或重载时用于移除其注册的菜单项
+type GameMenuRemover interface {
+	RemoveGameMenuEntry(*GameMenuEntry)
+}
+
 type GameMenuModule interface {
 	GameMenuSetter
 	CanPreInit
@@ -87,6 +92,11 @@ type BackendIO interface {
 	ForkOut(prefix string, logFile string) *MultiOutDst
 }
 
+// 可以移除菜单项的 BackendIO, 组件被停止或重载时用于移除其注册的菜单项
+type BackendMenuRemover interface {
+	RemoveBackendMenuEntry(*BackendMenuEntry)
+}
+
 type BackendIOModule interface {
 	BackendIO
 	CanPreInit
@@ -1,5 +1,7 @@
 package neomega_backbone
 
+import "time"
+
 type KVDBLike interface {
 	Get(key string) (value string)
 	Delete(key string)
@@ -7,6 +9,62 @@ type KVDBLike interface {
 	Iter(func(key, value string) bool)
 }
 
+// KVDBLikeWithErr 是 KVDBLike 的可选扩展, 用于得到 Set/Delete 失败的原因
+// Set/Delete 没有返回值, 写入失败(e.g. 超出 storage 的配额, 磁盘已满)时数据库不会被修改, Err 返回最近一次失败的错误
+// e.g. db.Set(k, v); if db, ok := kv.(KVDBLikeWithErr); ok && db.Err() != nil { ... }
+type KVDBLikeWithErr interface {
+	KVDBLike
+	Err() error
+}
+
+// KVDBBatch 收集一组写操作, Commit 时要么全部生效, 要么全部不生效
+// 即使进程在 Commit 过程中被杀死, 重新打开后也不会看到只生效了一半的 batch
+type KVDBBatch interface {
+	Set(key string, value string)
+	Delete(key string)
+	Commit() error
+	// 放弃所有尚未提交的写操作
+	Discard()
+}
+
+// KVDBLikeWithBatch 是 KVDBLike 的可选扩展, 使用时通过类型断言获得
+// e.g. if db, ok := kv.(KVDBLikeWithBatch); ok { b := db.NewBatch(); ...; b.Commit() }
+type KVDBLikeWithBatch interface {
+	KVDBLike
+	NewBatch() KVDBBatch
+}
+
+// KVDBLikeWithOrderedIter 是 KVDBLike 的可选扩展, 按 key 的字典序遍历
+// e.g. 以 player:<uuid>:... 形式保存数据时, 可以通过 IterPrefix("player:<uuid>:") 只取出一个玩家的数据
+type KVDBLikeWithOrderedIter interface {
+	KVDBLike
+	// 遍历所有以 prefix 开头的 key
+	IterPrefix(prefix string, fn func(key, value string) bool)
+	// 遍历 start <= key < end 的所有 key, end 为 "" 时表示没有上界
+	IterRange(start, end string, fn func(key, value string) bool)
+	Count() int
+	CountPrefix(prefix string) int
+}
+
+// KVDBLikeWithTTL 是 KVDBLike 的可选扩展, 支持会自动过期的 key (e.g. 冷却时间, 临时封禁, 邀请码)
+// 过期的 key 对 Get/Iter 不可见, 过期时间会被持久化, 重启后依然有效
+type KVDBLikeWithTTL interface {
+	KVDBLike
+	SetWithTTL(key string, value string, ttl time.Duration)
+	// 返回 key 的剩余存活时间, key 不存在或没有设置 TTL 时 hasTTL 为 false
+	TTL(key string) (ttl time.Duration, hasTTL bool)
+}
+
+// StagedWrite 收集一组对 ${data}/topic 的写入, Commit 后这些文件要么全部被更新, 要么全部保持原样
+// 即使进程在 Commit 过程中被杀死, 下次启动时也会被恢复为其中一种状态
+type StagedWrite interface {
+	Write(topic string, data []byte)
+	WriteJson(topic string, data interface{}) error
+	Commit() error
+	// 放弃所有尚未提交的写入
+	Abort()
+}
+
 type StorageAndPathAccess interface {
 	// ${log}/topic
 	GetLoggerPath(topic string) string
@@ -24,16 +82,43 @@ type StorageAndPathAccess interface {
 	GetArchivePath(elem ...string) string
 	// ${config}/topic
 	GetConfigPath(elem ...string) string
-	// ${temp}/random_name
+	// ${temp}/random_name, 创建失败时记录错误并返回无法使用的路径, 需要得到错误时使用 StorageAndPathAccessWithTempDirCleanup
 	NewTempDir() string
 	// ${lang_specific}/topic, e.g. ${lang_specific}/lua, ${lang_specific}/side-python
 	GetLangSpecificPath(elem ...string) string
 	// on system like android, we can not use "seek" or some specific file operation under download or dirs in public dir,
 	// which makes it impossible to use a normal database
 	// FileLogKVDBLike is a KVDBLike, which aims to work in a file-system where "seek" is not supported
+	// the reference implementation is file_log_kvdb.FileLogKVDB, dbType = file_log_kvdb.DBType
 	GetKVDBLike(saveDir string, dbType string) (KVDBLike, error)
 }
 
+// StorageAndPathAccessWithStagedWrite 是 StorageAndPathAccess 的可选扩展, 使用时通过类型断言获得
+// e.g. if s, ok := storage.(StorageAndPathAccessWithStagedWrite); ok { w := s.BeginWrite(); ...; w.Commit() }
+type StorageAndPathAccessWithStagedWrite interface {
+	StorageAndPathAccess
+	// 同时更新 ${data} 下多个文件, e.g. 一个索引文件和若干数据文件
+	BeginWrite() StagedWrite
+}
+
+// StorageAndPathAccessWithTempDirCleanup 是 StorageAndPathAccess 的可选扩展, 创建可以单独删除的临时目录
+type StorageAndPathAccessWithTempDirCleanup interface {
+	StorageAndPathAccess
+	// ${temp}/random_name, 不再使用时调用 release 删除该目录
+	// 未被删除的临时目录会在所属组件停止或下次启动时被清理
+	NewTempDirWithCleanup() (dir string, release func(), err error)
+}
+
+// StorageAndPathAccessWithWatch 是 StorageAndPathAccess 的可选扩展, 监听文件的变化
+type StorageAndPathAccessWithWatch interface {
+	StorageAndPathAccess
+	// 监听 path(通常来自 GetConfigPath/GetFilePath) 的变化, path 为目录时监听其直接子项, cb 的参数为发生变化的路径
+	// 短时间内的多次修改被合并, 每个发生变化的路径触发一次 cb, 无法确定哪些路径发生了变化时(e.g. 目录被替换)以 path 本身触发
+	// 调用 cancel 停止监听
+	Watch(path string, cb func(changed string)) (cancel func(), err error)
+}
+
+// storage.StorageAndPath 是基于本地文件系统的实现, 测试时可以使用 storage.NewTempStorage()
 type StorageAndPathProvider interface {
 	StorageAndPathAccess
 	//
@@ -0,0 +1,373 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	snapshotPrefix     = "snapshot-"
+	snapshotSuffix     = ".tar.gz"
+	snapshotTimeLayout = "20060102-150405.000"
+)
+
+type SnapshotInfo struct {
+	// 文件名, 也用于 RestoreSnapshot
+	Name string
+	Path string
+	Time time.Time
+	Size int64
+}
+
+// RetentionPolicy 中的条件同时生效, 为 0 的条件不生效
+type RetentionPolicy struct {
+	// 至少保留最新的 KeepLast 个快照
+	KeepLast int
+	// 超过 MaxAge 的快照会被删除(但仍受 KeepLast 保护)
+	MaxAge time.Duration
+	// 快照数量超过 MaxCount 时, 删除最旧的
+	MaxCount int
+}
+
+// snapshotRoots 返回快照中包含的目录, key 为其在 tar 包中的名字
+func (s *StorageAndPath) snapshotRoots() map[string]string {
+	return map[string]string{
+		"data":   s.roots.Data,
+		"config": s.roots.Config,
+	}
+}
+
+// syncKVDBs 将所有已打开数据库的数据刷到磁盘
+// FileLogKVDB 只追加写入, 在此之后读取到的日志前缀总是一个一致的状态
+func (s *StorageAndPath) syncKVDBs() {
+	s.kvdbMu.Lock()
+	defer s.kvdbMu.Unlock()
+	for _, db := range s.kvdbs {
+		if syncer, ok := db.(interface{ Sync() error }); ok {
+			syncer.Sync()
+		}
+	}
+}
+
+// Snapshot 将 ${data} 和 ${config} 打包为 ${archive}/snapshot-<时间>.tar.gz
+func (s *StorageAndPath) Snapshot() (SnapshotInfo, error) {
+	s.syncKVDBs()
+	now := time.Now()
+	name := snapshotPrefix + now.Format(snapshotTimeLayout) + snapshotSuffix
+	if err := os.MkdirAll(s.roots.Archive, 0755); err != nil {
+		return SnapshotInfo{}, err
+	}
+	p := s.GetArchivePath(name)
+	tmpPath := p + ".tmp"
+	fp, err := os.Create(tmpPath)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	gw := gzip.NewWriter(fp)
+	tw := tar.NewWriter(gw)
+	roots := s.snapshotRoots()
+	prefixes := make([]string, 0, len(roots))
+	for prefix := range roots {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		if err = addDirToTar(tw, prefix, roots[prefix]); err != nil {
+			break
+		}
+	}
+	if closeErr := tw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := gw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := fp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, p)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return SnapshotInfo{}, err
+	}
+	stat, err := os.Stat(p)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	return SnapshotInfo{Name: name, Path: p, Time: now, Size: stat.Size()}, nil
+}
+
+func addDirToTar(tw *tar.Writer, prefix string, root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(filepath.Join(prefix, rel)) + "/"
+			return tw.WriteHeader(header)
+		}
+		// 文件可能在此期间被替换(e.g. 数据库压缩)或追加, 因此从打开的文件读到 EOF, 以实际读到的内容为准
+		fp, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		info, err := fp.Stat()
+		if err != nil {
+			return err
+		}
+		content, err := io.ReadAll(fp)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(prefix, rel))
+		header.Size = int64(len(content))
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = tw.Write(content)
+		return err
+	})
+}
+
+// ListSnapshots 按时间从新到旧返回所有快照
+func (s *StorageAndPath) ListSnapshots() ([]SnapshotInfo, error) {
+	entries, err := os.ReadDir(s.roots.Archive)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []SnapshotInfo{}, nil
+		}
+		return nil, err
+	}
+	snapshots := []SnapshotInfo{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+		t, err := time.ParseInLocation(snapshotTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix), time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, SnapshotInfo{Name: name, Path: s.GetArchivePath(name), Time: t, Size: info.Size()})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// PruneSnapshots 按 policy 删除旧快照, 返回被删除的快照
+func (s *StorageAndPath) PruneSnapshots(policy RetentionPolicy) ([]SnapshotInfo, error) {
+	snapshots, err := s.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	removed := []SnapshotInfo{}
+	now := time.Now()
+	for i, snapshot := range snapshots {
+		if i < policy.KeepLast {
+			continue
+		}
+		tooOld := policy.MaxAge > 0 && now.Sub(snapshot.Time) > policy.MaxAge
+		tooMany := policy.MaxCount > 0 && i >= policy.MaxCount
+		if !tooOld && !tooMany {
+			continue
+		}
+		if err := os.Remove(snapshot.Path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, snapshot)
+	}
+	return removed, nil
+}
+
+// RestoreSnapshot 用快照 name 中的内容替换 ${data} 和 ${config}
+// 恢复前会关闭所有已打开的数据库, 并先为当前状态创建一个快照, 因此恢复操作本身也可以被撤销
+// 快照被解压到各个目录旁边(同一文件系统)的临时目录中, 然后将原目录移开再换入, 任何一步失败时所有目录都会被换回
+// 恢复后组件需要重新获取数据库, 因此最好在恢复后重启程序
+func (s *StorageAndPath) RestoreSnapshot(name string) (backup SnapshotInfo, err error) {
+	if name != filepath.Base(name) {
+		return backup, fmt.Errorf("storage: invalid snapshot name %v", name)
+	}
+	p := s.GetArchivePath(name)
+	if _, err := os.Stat(p); err != nil {
+		return backup, err
+	}
+	roots := s.snapshotRoots()
+	prefixes := make([]string, 0, len(roots))
+	for prefix := range roots {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	stagings := map[string]string{}
+	defer func() {
+		for _, staging := range stagings {
+			os.RemoveAll(staging)
+		}
+	}()
+	for _, prefix := range prefixes {
+		root := roots[prefix]
+		if err := os.MkdirAll(filepath.Dir(root), 0755); err != nil {
+			return backup, err
+		}
+		if stagings[prefix], err = os.MkdirTemp(filepath.Dir(root), filepath.Base(root)+".restoring-"); err != nil {
+			return backup, err
+		}
+	}
+	if err := extractTarGz(p, stagings); err != nil {
+		return backup, err
+	}
+	if backup, err = s.Snapshot(); err != nil {
+		return backup, err
+	}
+	s.closeKVDBs()
+	return backup, swapDirs(prefixes, roots, stagings)
+}
+
+// swapDirs 依次将 roots[prefix] 移开并以 stagings[prefix] 替换, 失败时撤销已经完成的替换
+// 成功后删除被移开的目录
+func swapDirs(prefixes []string, roots, stagings map[string]string) (err error) {
+	type swapped struct {
+		root, aside string
+	}
+	done := []swapped{}
+	defer func() {
+		if err == nil {
+			for _, d := range done {
+				os.RemoveAll(d.aside)
+			}
+			return
+		}
+		for i := len(done) - 1; i >= 0; i-- {
+			d := done[i]
+			if rollbackErr := os.Rename(d.root, stagings[prefixes[i]]); rollbackErr != nil {
+				err = errors.Join(err, rollbackErr)
+				continue
+			}
+			if d.aside != "" {
+				if rollbackErr := os.Rename(d.aside, d.root); rollbackErr != nil {
+					err = errors.Join(err, fmt.Errorf("storage: %v was moved to %v and could not be moved back: %w", d.root, d.aside, rollbackErr))
+				}
+			}
+		}
+	}()
+	for _, prefix := range prefixes {
+		root := roots[prefix]
+		aside := ""
+		if _, statErr := os.Stat(root); statErr == nil {
+			aside = root + ".old-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+			if err := os.Rename(root, aside); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(statErr) {
+			return statErr
+		}
+		if err := os.Rename(stagings[prefix], root); err != nil {
+			if aside != "" {
+				if rollbackErr := os.Rename(aside, root); rollbackErr != nil {
+					err = errors.Join(err, rollbackErr)
+				}
+			}
+			return err
+		}
+		done = append(done, swapped{root: root, aside: aside})
+	}
+	return nil
+}
+
+// extractTarGz 将 src 中以 prefix/ 开头的内容解压到 dsts[prefix] 下
+func extractTarGz(src string, dsts map[string]string) error {
+	fp, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	gr, err := gzip.NewReader(fp)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("storage: invalid path %v in snapshot", header.Name)
+		}
+		parts := strings.SplitN(filepath.ToSlash(filepath.Clean(name)), "/", 2)
+		dst, ok := dsts[parts[0]]
+		if !ok {
+			return fmt.Errorf("storage: unexpected path %v in snapshot", header.Name)
+		}
+		target := dst
+		if len(parts) == 2 {
+			target = filepath.Join(dst, filepath.FromSlash(parts[1]))
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"strconv"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+// AddArchiveBackendMenu 注册终端菜单项 archive, 用法:
+// archive             创建快照
+// archive list        列出所有快照
+// archive prune       按 policy 清理旧快照
+// archive restore <n> 恢复第 n 个快照(序号见 archive list)
+func AddArchiveBackendMenu(s *StorageAndPath, backend neomega_backbone.BackendIO, policy RetentionPolicy) {
+	out := backend.Out()
+	backend.AddBackendMenuEntry(&neomega_backbone.BackendMenuEntry{
+		MenuEntry: neomega_backbone.MenuEntry{
+			Triggers:     []string{"archive", "存档"},
+			ArgumentHint: "[list|prune|restore <序号>]",
+			Usage:        "为数据和配置文件创建快照, 列出/清理/恢复快照",
+		},
+		OnTrigCallBack: func(cmds []string) {
+			action := ""
+			if len(cmds) > 0 {
+				action = cmds[0]
+			}
+			switch action {
+			case "":
+				snapshot, err := s.Snapshot()
+				if err != nil {
+					out.Error.Printfln("创建快照失败: %v", err)
+					return
+				}
+				out.Success.Printfln("已创建快照 %v (%v 字节)", snapshot.Name, snapshot.Size)
+				if removed, err := s.PruneSnapshots(policy); err != nil {
+					out.Warning.Printfln("清理旧快照失败: %v", err)
+				} else if len(removed) > 0 {
+					out.Info.Printfln("已清理 %v 个旧快照", len(removed))
+				}
+			case "list":
+				snapshots, err := s.ListSnapshots()
+				if err != nil {
+					out.Error.Printfln("读取快照列表失败: %v", err)
+					return
+				}
+				if len(snapshots) == 0 {
+					out.Info.Println("没有快照")
+				}
+				for i, snapshot := range snapshots {
+					out.Info.Printfln("[%v] %v %v (%v 字节)", i+1, snapshot.Time.Format("2006-01-02 15:04:05"), snapshot.Name, snapshot.Size)
+				}
+			case "prune":
+				removed, err := s.PruneSnapshots(policy)
+				if err != nil {
+					out.Error.Printfln("清理旧快照失败(已清理 %v 个): %v", len(removed), err)
+					return
+				}
+				out.Success.Printfln("已清理 %v 个旧快照", len(removed))
+			case "restore":
+				snapshots, err := s.ListSnapshots()
+				if err != nil {
+					out.Error.Printfln("读取快照列表失败: %v", err)
+					return
+				}
+				if len(cmds) < 2 {
+					out.Warning.Println("请指定要恢复的快照序号, 序号见 archive list")
+					return
+				}
+				i, err := strconv.Atoi(cmds[1])
+				if err != nil || i < 1 || i > len(snapshots) {
+					out.Warning.Printfln("无效的快照序号 %v", cmds[1])
+					return
+				}
+				backup, err := s.RestoreSnapshot(snapshots[i-1].Name)
+				if err != nil {
+					out.Error.Printfln("恢复快照失败: %v", err)
+					return
+				}
+				out.Success.Printfln("已恢复快照 %v, 恢复前的状态保存在 %v, 请重启程序", snapshots[i-1].Name, backup.Name)
+			default:
+				out.Warning.Printfln("未知的操作 %v, 可用的操作: list, prune, restore <序号>", action)
+			}
+		},
+	})
+}
@@ -0,0 +1,419 @@
+package storage
+
+import (
+	"archive/zip"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrReadOnly = errors.New("storage: read-only backend")
+
+// StorageBackend 是 StorageAndPath 读写 ${data}/topic 时实际使用的存储层
+// name 均为以 / 分隔的相对路径, 文件不存在时返回的 error 满足 errors.Is(err, fs.ErrNotExist)
+type StorageBackend interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+	Rename(oldName, newName string) error
+	// Remove 也可以删除空目录
+	Remove(name string) error
+	// ReadDir 返回目录 name 下的文件和子目录名, 按字典序排列
+	ReadDir(name string) ([]string, error)
+	// Stat 返回文件的信息, 用于配额计算等只需要大小的场合
+	Stat(name string) (fs.FileInfo, error)
+}
+
+func cleanName(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))[1:]
+}
+
+func notExist(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func entryNames(entries []fs.DirEntry) []string {
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+// LocalBackend 将文件保存在本地目录 root 下
+type LocalBackend struct {
+	root string
+}
+
+func NewLocalBackend(root string) *LocalBackend {
+	return &LocalBackend{root: root}
+}
+
+// path 返回 name 对应的本地路径, 指向 root 之外(包括通过符号链接)时返回 PathEscapeError
+func (b *LocalBackend) path(name string) (string, error) {
+	if err := checkTopic(b.root, name); err != nil {
+		return "", err
+	}
+	return ResolvePath(b.root, filepath.FromSlash(name))
+}
+
+func (b *LocalBackend) ReadFile(name string) ([]byte, error) {
+	p, err := b.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+func (b *LocalBackend) WriteFile(name string, data []byte) error {
+	p, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0644)
+}
+
+func (b *LocalBackend) Rename(oldName, newName string) error {
+	oldPath, err := b.path(oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := b.path(newName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func (b *LocalBackend) Remove(name string) error {
+	p, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (b *LocalBackend) ReadDir(name string) ([]string, error) {
+	p := b.root
+	if name != "" && name != "." {
+		var err error
+		if p, err = b.path(name); err != nil {
+			return nil, err
+		}
+	}
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	return entryNames(entries), nil
+}
+
+func (b *LocalBackend) Stat(name string) (fs.FileInfo, error) {
+	p, err := b.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+// MemoryBackend 将文件保存在内存中
+type MemoryBackend struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{files: map[string][]byte{}}
+}
+
+func (b *MemoryBackend) ReadFile(name string) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	data, ok := b.files[cleanName(name)]
+	if !ok {
+		return nil, notExist("open", name)
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (b *MemoryBackend) WriteFile(name string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files[cleanName(name)] = append([]byte{}, data...)
+	return nil
+}
+
+func (b *MemoryBackend) Rename(oldName, newName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.files[cleanName(oldName)]
+	if !ok {
+		return notExist("rename", oldName)
+	}
+	delete(b.files, cleanName(oldName))
+	b.files[cleanName(newName)] = data
+	return nil
+}
+
+// MemoryBackend 中没有真正的目录, 目录在其下第一个文件写入时出现, 在最后一个文件删除时消失
+func (b *MemoryBackend) Remove(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.files[cleanName(name)]; !ok {
+		if b.isDir(cleanName(name)) {
+			return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+		return notExist("remove", name)
+	}
+	delete(b.files, cleanName(name))
+	return nil
+}
+
+func (b *MemoryBackend) isDir(name string) bool {
+	for file := range b.files {
+		if name == "" || strings.HasPrefix(file, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *MemoryBackend) ReadDir(name string) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	prefix := cleanName(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+	seen := map[string]bool{}
+	for file := range b.files {
+		if rest := strings.TrimPrefix(file, prefix); rest != file || prefix == "" {
+			seen[strings.SplitN(rest, "/", 2)[0]] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil, notExist("readdir", name)
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// memFileInfo 是 MemoryBackend 中文件或目录的 fs.FileInfo
+type memFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) ModTime() time.Time { return time.Time{} }
+func (i memFileInfo) IsDir() bool        { return i.dir }
+func (i memFileInfo) Sys() any           { return nil }
+
+func (i memFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (b *MemoryBackend) Stat(name string) (fs.FileInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if data, ok := b.files[cleanName(name)]; ok {
+		return memFileInfo{name: path.Base(cleanName(name)), size: int64(len(data))}, nil
+	}
+	if b.isDir(cleanName(name)) {
+		return memFileInfo{name: path.Base(cleanName(name)), dir: true}, nil
+	}
+	return nil, notExist("stat", name)
+}
+
+// FSBackend 是基于 fs.FS 的只读存储层, 可用于 embed.FS 中的默认数据
+type FSBackend struct {
+	fsys fs.FS
+}
+
+func NewFSBackend(fsys fs.FS) *FSBackend {
+	return &FSBackend{fsys: fsys}
+}
+
+func (b *FSBackend) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(b.fsys, cleanName(name))
+}
+
+func (b *FSBackend) WriteFile(name string, data []byte) error {
+	return ErrReadOnly
+}
+
+func (b *FSBackend) Rename(oldName, newName string) error {
+	return ErrReadOnly
+}
+
+func (b *FSBackend) Remove(name string) error {
+	return ErrReadOnly
+}
+
+func (b *FSBackend) ReadDir(name string) ([]string, error) {
+	dir := cleanName(name)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := fs.ReadDir(b.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return entryNames(entries), nil
+}
+
+func (b *FSBackend) Stat(name string) (fs.FileInfo, error) {
+	p := cleanName(name)
+	if p == "" {
+		p = "."
+	}
+	return fs.Stat(b.fsys, p)
+}
+
+// NewZipBackend 以只读方式打开 zip 文件, 不再使用时需要调用 Close
+func NewZipBackend(zipFile string) (backend *FSBackend, close func() error, err error) {
+	r, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewFSBackend(r), r.Close, nil
+}
+
+// readOnlyBackend 屏蔽了 backend 的所有写操作
+type readOnlyBackend struct {
+	backend StorageBackend
+}
+
+func NewReadOnlyBackend(backend StorageBackend) StorageBackend {
+	return &readOnlyBackend{backend: backend}
+}
+
+func (b *readOnlyBackend) ReadFile(name string) ([]byte, error) {
+	return b.backend.ReadFile(name)
+}
+
+func (b *readOnlyBackend) WriteFile(name string, data []byte) error {
+	return ErrReadOnly
+}
+
+func (b *readOnlyBackend) Rename(oldName, newName string) error {
+	return ErrReadOnly
+}
+
+func (b *readOnlyBackend) Remove(name string) error {
+	return ErrReadOnly
+}
+
+func (b *readOnlyBackend) ReadDir(name string) ([]string, error) {
+	return b.backend.ReadDir(name)
+}
+
+func (b *readOnlyBackend) Stat(name string) (fs.FileInfo, error) {
+	return b.backend.Stat(name)
+}
+
+// OverlayBackend 由一个可写的上层和若干只读的下层组成
+// 读取时依次查找上层和各个下层, 写入总是发生在上层
+// e.g. NewOverlayBackend(NewLocalBackend(dataDir), NewFSBackend(embeddedDefaults))
+type OverlayBackend struct {
+	upper  StorageBackend
+	lowers []StorageBackend
+}
+
+func NewOverlayBackend(upper StorageBackend, lowers ...StorageBackend) *OverlayBackend {
+	return &OverlayBackend{upper: upper, lowers: lowers}
+}
+
+func (b *OverlayBackend) ReadFile(name string) ([]byte, error) {
+	data, err := b.upper.ReadFile(name)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return data, err
+	}
+	for _, lower := range b.lowers {
+		data, err = lower.ReadFile(name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return data, err
+		}
+	}
+	return nil, notExist("open", name)
+}
+
+func (b *OverlayBackend) WriteFile(name string, data []byte) error {
+	return b.upper.WriteFile(name, data)
+}
+
+// Rename 的源文件只存在于下层时, 会被复制到上层
+func (b *OverlayBackend) Rename(oldName, newName string) error {
+	err := b.upper.Rename(oldName, newName)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	data, err := b.ReadFile(oldName)
+	if err != nil {
+		return err
+	}
+	return b.upper.WriteFile(newName, data)
+}
+
+// Remove 只会删除上层中的文件, 若下层中存在同名文件, 删除后读取到的将是下层的文件
+func (b *OverlayBackend) Remove(name string) error {
+	return b.upper.Remove(name)
+}
+
+// ReadDir 返回各层中该目录内容的并集
+func (b *OverlayBackend) ReadDir(name string) ([]string, error) {
+	seen := map[string]bool{}
+	found := false
+	for _, layer := range append([]StorageBackend{b.upper}, b.lowers...) {
+		names, err := layer.ReadDir(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, n := range names {
+			seen[n] = true
+		}
+	}
+	if !found {
+		return nil, notExist("readdir", name)
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *OverlayBackend) Stat(name string) (fs.FileInfo, error) {
+	for _, layer := range append([]StorageBackend{b.upper}, b.lowers...) {
+		info, err := layer.Stat(name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return info, err
+		}
+	}
+	return nil, notExist("stat", name)
+}
@@ -0,0 +1,361 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 配额按根目录(${data}, ${cache})分别计算, 组件指的是根目录下的第一级目录, e.g. ${data}/<组件名>/...
+// ${data} 的写入在超出配额时返回 QuotaError(KVDBLike.Set 的错误通过 neomega_backbone.KVDBLikeWithErr 得到); ${cache} 中的文件由组件通过路径直接写入, 无法在写入前检查,
+// 因此设置了 ${cache} 配额后会定期检查, 超出配额时按修改时间从旧到新删除缓存文件, 见 EnforceCacheQuota
+// ScopedStorage 的所有数据都位于 ${data}/<name>/ 和 ${cache}/<name>/ 下, 因此天然对应一个组件
+// 用量通过扫描本地目录得到, 之后随写入增量更新, 并定期重新扫描以修正压缩, 清理缓存和外部修改造成的偏差
+
+var ErrQuotaExceeded = errors.New("storage: quota exceeded")
+
+type QuotaError struct {
+	Root      string
+	Component string
+	Limit     int64
+	Usage     int64
+	Requested int64
+}
+
+func (e *QuotaError) Error() string {
+	target := e.Root
+	if e.Component != "" {
+		target = path.Join(e.Root, e.Component)
+	}
+	return fmt.Sprintf("storage: quota of %v exceeded, limit %v bytes, used %v bytes, requested %v bytes", target, e.Limit, e.Usage, e.Requested)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// QuotaLimits 中各项的单位为字节, <=0 表示不限制
+type QuotaLimits struct {
+	Data  int64
+	Cache int64
+}
+
+const (
+	quotaRootData  = "data"
+	quotaRootCache = "cache"
+)
+
+func (l QuotaLimits) of(root string) int64 {
+	if root == quotaRootCache {
+		return l.Cache
+	}
+	return l.Data
+}
+
+type QuotaUsage struct {
+	Component string
+	Data      int64
+	Cache     int64
+	Limits    QuotaLimits
+}
+
+type quotaUsage struct {
+	bytes   int64
+	scanned time.Time
+}
+
+type quotaManager struct {
+	mu   sync.Mutex
+	dirs map[string]string
+	// 用于获取 ${data}/topic 写入前的大小
+	backend        StorageBackend
+	enabled        bool
+	rescanInterval time.Duration
+	total          QuotaLimits
+	defaults       QuotaLimits
+	components     map[string]QuotaLimits
+	// key 为 root + "/" + 组件名, 组件名为 "" 时表示整个根目录
+	usage map[string]*quotaUsage
+	// 设置了 ${cache} 配额后定期清理缓存, 关闭以停止
+	stopEnforce chan struct{}
+}
+
+func newQuotaManager(roots Roots, backend StorageBackend) *quotaManager {
+	return &quotaManager{
+		dirs:           map[string]string{quotaRootData: roots.Data, quotaRootCache: roots.Cache},
+		backend:        backend,
+		rescanInterval: time.Minute,
+		components:     map[string]QuotaLimits{},
+		usage:          map[string]*quotaUsage{},
+	}
+}
+
+// componentOf 返回 topic 所属的组件, 根目录下的文件不属于任何组件
+func componentOf(topic string) string {
+	topic = path.Clean(filepath.ToSlash(topic))
+	if i := strings.Index(topic, "/"); i > 0 {
+		return topic[:i]
+	}
+	return ""
+}
+
+func (q *quotaManager) limitOf(root, component string) int64 {
+	if component == "" {
+		return q.total.of(root)
+	}
+	if limits, ok := q.components[component]; ok {
+		return limits.of(root)
+	}
+	return q.defaults.of(root)
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// usageOf 调用者需要持有锁
+func (q *quotaManager) usageOf(root, component string) *quotaUsage {
+	key := root + "/" + component
+	u, ok := q.usage[key]
+	if !ok || time.Since(u.scanned) > q.rescanInterval {
+		dir := q.dirs[root]
+		if component != "" {
+			dir = filepath.Join(dir, component)
+		}
+		u = &quotaUsage{bytes: dirSize(dir), scanned: time.Now()}
+		q.usage[key] = u
+	}
+	return u
+}
+
+// reserve 在写入 delta 字节之前检查配额, 通过后计入用量, delta 可以为负
+func (q *quotaManager) reserve(root, component string, delta int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.enabled {
+		return nil
+	}
+	targets := []string{""}
+	if component != "" {
+		targets = append(targets, component)
+	}
+	if delta > 0 {
+		for _, target := range targets {
+			limit := q.limitOf(root, target)
+			if limit <= 0 {
+				continue
+			}
+			if u := q.usageOf(root, target); u.bytes+delta > limit {
+				return &QuotaError{Root: root, Component: target, Limit: limit, Usage: u.bytes, Requested: delta}
+			}
+		}
+	}
+	for _, target := range targets {
+		q.usageOf(root, target).bytes += delta
+	}
+	return nil
+}
+
+// reserveWrite 在 ${data}/topic 被替换为 size 字节之前检查配额, 返回用于写入失败时撤销的函数
+func (q *quotaManager) reserveWrite(topic string, size int64) (release func(), err error) {
+	delta := size
+	if info, err := q.backend.Stat(topic); err == nil {
+		delta -= info.Size()
+	}
+	component := componentOf(topic)
+	if err := q.reserve(quotaRootData, component, delta); err != nil {
+		return nil, err
+	}
+	return func() { q.reserve(quotaRootData, component, -delta) }, nil
+}
+
+// enableLocked 启用配额, limits 中有 ${cache} 配额时启动定期清理, 调用者需要持有锁
+func (q *quotaManager) enableLocked(limits QuotaLimits) {
+	q.enabled = true
+	if limits.Cache > 0 && q.stopEnforce == nil {
+		q.stopEnforce = make(chan struct{})
+		go q.enforceLoop(q.stopEnforce, q.rescanInterval)
+	}
+}
+
+func (q *quotaManager) enforceLoop(stop chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			q.enforceCache()
+		}
+	}
+}
+
+func (q *quotaManager) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopEnforce != nil {
+		close(q.stopEnforce)
+		q.stopEnforce = nil
+	}
+}
+
+// enforceCache 对超出 ${cache} 配额的组件(以及整个 ${cache})删除最久未修改的文件, 返回删除的字节数
+func (q *quotaManager) enforceCache() (freed int64, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.enabled {
+		return 0, nil
+	}
+	root := q.dirs[quotaRootCache]
+	targets := []string{}
+	entries, _ := os.ReadDir(root)
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			targets = append(targets, entry.Name())
+		}
+	}
+	// 先清理各个组件, 再检查总量
+	targets = append(targets, "")
+	var errs []error
+	for _, target := range targets {
+		limit := q.limitOf(quotaRootCache, target)
+		if limit <= 0 {
+			continue
+		}
+		n, err := evictOldest(filepath.Join(root, target), limit)
+		freed += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// 用量在下次使用时重新扫描
+	for key := range q.usage {
+		if strings.HasPrefix(key, quotaRootCache+"/") {
+			delete(q.usage, key)
+		}
+	}
+	return freed, errors.Join(errs...)
+}
+
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// evictOldest 按修改时间从旧到新删除 dir 下的文件, 直到总大小不超过 limit
+// 以 "." 开头的目录(e.g. cache_manager 正在生成的文件)不会被清理, 也不计入总大小
+func evictOldest(dir string, limit int64) (int64, error) {
+	var files []cacheFile
+	var total int64
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files = append(files, cacheFile{path: p, size: info.Size(), modTime: info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	var freed int64
+	var errs []error
+	for _, f := range files {
+		if total-freed <= limit {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+			continue
+		}
+		freed += f.size
+	}
+	return freed, errors.Join(errs...)
+}
+
+// SetComponentQuota 设置组件的配额
+func (s *StorageAndPath) SetComponentQuota(component string, limits QuotaLimits) {
+	s.quota.mu.Lock()
+	defer s.quota.mu.Unlock()
+	s.quota.enableLocked(limits)
+	s.quota.components[component] = limits
+}
+
+// SetDefaultComponentQuota 设置没有单独设置配额的组件的配额
+func (s *StorageAndPath) SetDefaultComponentQuota(limits QuotaLimits) {
+	s.quota.mu.Lock()
+	defer s.quota.mu.Unlock()
+	s.quota.enableLocked(limits)
+	s.quota.defaults = limits
+}
+
+// SetTotalQuota 设置整个 ${data} 和 ${cache} 的配额
+func (s *StorageAndPath) SetTotalQuota(limits QuotaLimits) {
+	s.quota.mu.Lock()
+	defer s.quota.mu.Unlock()
+	s.quota.enableLocked(limits)
+	s.quota.total = limits
+}
+
+// EnforceCacheQuota 立即清理超出配额的缓存, 返回删除的字节数, 设置了 ${cache} 配额后也会定期自动执行
+// 缓存文件可能在组件使用期间被删除, 组件需要能够重新生成它们, cache_manager 会自动处理
+func (s *StorageAndPath) EnforceCacheQuota() (freed int64, err error) {
+	return s.quota.enforceCache()
+}
+
+// QuotaUsages 重新扫描并返回所有组件的用量, 第一项(Component 为 "")是整个根目录的用量
+func (s *StorageAndPath) QuotaUsages() []QuotaUsage {
+	q := s.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.usage = map[string]*quotaUsage{}
+	components := map[string]bool{}
+	for _, dir := range q.dirs {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				components[entry.Name()] = true
+			}
+		}
+	}
+	names := []string{""}
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	usages := make([]QuotaUsage, 0, len(names))
+	for _, name := range names {
+		usages = append(usages, QuotaUsage{
+			Component: name,
+			Data:      q.usageOf(quotaRootData, name).bytes,
+			Cache:     q.usageOf(quotaRootCache, name).bytes,
+			Limits:    QuotaLimits{Data: q.limitOf(quotaRootData, name), Cache: q.limitOf(quotaRootCache, name)},
+		})
+	}
+	return usages
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"fmt"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatUsage(used, limit int64) string {
+	if limit <= 0 {
+		return formatBytes(used)
+	}
+	return fmt.Sprintf("%v/%v", formatBytes(used), formatBytes(limit))
+}
+
+// AddQuotaBackendMenu 注册终端菜单项 quota, 显示每个组件的磁盘用量和配额
+func AddQuotaBackendMenu(s *StorageAndPath, backend neomega_backbone.BackendIO) {
+	out := backend.Out()
+	backend.AddBackendMenuEntry(&neomega_backbone.BackendMenuEntry{
+		MenuEntry: neomega_backbone.MenuEntry{
+			Triggers: []string{"quota", "配额"},
+			Usage:    "显示每个组件的数据和缓存用量",
+		},
+		OnTrigCallBack: func(cmds []string) {
+			for _, usage := range s.QuotaUsages() {
+				name := usage.Component
+				if name == "" {
+					name = "(总计)"
+				}
+				out.Info.Printfln("%v 数据: %v 缓存: %v", name, formatUsage(usage.Data, usage.Limits.Data), formatUsage(usage.Cache, usage.Limits.Cache))
+			}
+		},
+	})
+}
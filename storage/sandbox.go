@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrPathEscape 表示路径试图访问其根目录之外的位置, e.g. topic 为 "../../etc/passwd"
+var ErrPathEscape = errors.New("storage: path escapes its root")
+
+type PathEscapeError struct {
+	Root string
+	Path string
+}
+
+func (e *PathEscapeError) Error() string {
+	return fmt.Sprintf("storage: path %v escapes its root %v", e.Path, e.Root)
+}
+
+func (e *PathEscapeError) Unwrap() error {
+	return ErrPathEscape
+}
+
+// confine 将 elem 视为以 root 为根的路径, ".." 最多回到 root, 然后解析其中已经存在的部分的符号链接
+// 解析后不在 root 之下时(e.g. root 下有指向外部的符号链接)返回 PathEscapeError
+func confine(root string, elem ...string) (string, error) {
+	rel := filepath.Join(append([]string{string(filepath.Separator)}, elem...)...)
+	p := filepath.Join(root, rel)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		// 根目录尚不存在, 其下也不可能存在符号链接
+		return p, nil
+	}
+	if !within(realRoot, evalExisting(p)) {
+		return "", &PathEscapeError{Root: root, Path: filepath.Join(elem...)}
+	}
+	return p, nil
+}
+
+// escapedPath 是 confinedPath 在路径逃出根目录时返回的路径
+// 其中包含 NUL, 对它以及由它拼接出的路径的任何文件操作都会失败, 因此不会访问到根目录之外
+const escapedPath = "\x00path-escapes-root"
+
+// confinedPath 用于 GetFilePath 等只能返回路径的方法, 需要得到错误时使用 confine (e.g. ResolveFilePath)
+func confinedPath(root string, elem ...string) string {
+	p, err := confine(root, elem...)
+	if err != nil {
+		return filepath.Join(root, escapedPath)
+	}
+	return p
+}
+
+// checkTopic 检查 topic 是否为 root 下的相对路径, 不允许绝对路径和跳出 root 的 ".."
+func checkTopic(root string, topic string) error {
+	if !filepath.IsLocal(filepath.FromSlash(topic)) {
+		return &PathEscapeError{Root: root, Path: topic}
+	}
+	return nil
+}
+
+// ResolvePath 返回 root/elem..., 若其(在解析符号链接后)不在 root 之下, 返回 PathEscapeError
+func ResolvePath(root string, elem ...string) (string, error) {
+	p := filepath.Join(append([]string{root}, elem...)...)
+	if !within(root, p) {
+		return "", &PathEscapeError{Root: root, Path: filepath.Join(elem...)}
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		// 根目录尚不存在, 其下也不可能存在符号链接
+		return p, nil
+	}
+	if !within(realRoot, evalExisting(p)) {
+		return "", &PathEscapeError{Root: root, Path: filepath.Join(elem...)}
+	}
+	return p, nil
+}
+
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// evalExisting 解析 p 中已经存在的部分的符号链接, 不存在的部分原样拼接在后面
+func evalExisting(p string) string {
+	rest := ""
+	for {
+		real, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(real, rest)
+		}
+		if !os.IsNotExist(err) {
+			return filepath.Join(p, rest)
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return filepath.Join(p, rest)
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+}
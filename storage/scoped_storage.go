@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+// ScopedStorage 是只能看到 ${data}/<name>/ 的 StorageAndPathAccess, 用于交给单个组件(尤其是 lua/python 插件)
+// 其中所有 ${data} 相关的 topic 和路径都相对于 ${data}/<name>/, 其余的路径同样位于各自根目录的 <name>/ 下,
+// e.g. 缓存位于 ${cache}/<name>/, 配置位于 ${config}/<name>/, 因此组件无法读取其他组件的配置和 ${archive} 中的快照
+type ScopedStorage struct {
+	parent      *StorageAndPath
+	name        string
+	root        string
+	cacheRoot   string
+	logRoot     string
+	archiveRoot string
+	configRoot  string
+	langRoot    string
+}
+
+var ErrInvalidScope = errors.New("storage: invalid scope name")
+
+var (
+	_ neomega_backbone.StorageAndPathAccessWithStagedWrite    = (*ScopedStorage)(nil)
+	_ neomega_backbone.StorageAndPathAccessWithTempDirCleanup = (*ScopedStorage)(nil)
+	_ neomega_backbone.StorageAndPathAccessWithWatch          = (*ScopedStorage)(nil)
+)
+
+// Scoped 返回名为 name 的 ScopedStorage, name 必须是 ${data} 下的子目录, 不能为 "" 或 "."
+func (s *StorageAndPath) Scoped(name string) (*ScopedStorage, error) {
+	if err := checkTopic(s.roots.Data, name); err != nil {
+		return nil, err
+	}
+	name = path.Clean(filepath.ToSlash(name))
+	if name == "." {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidScope, name)
+	}
+	under := func(root string) string {
+		return filepath.Join(root, filepath.FromSlash(name))
+	}
+	return &ScopedStorage{
+		parent:      s,
+		name:        name,
+		root:        under(s.roots.Data),
+		cacheRoot:   under(s.roots.Cache),
+		logRoot:     under(s.roots.Log),
+		archiveRoot: under(s.roots.Archive),
+		configRoot:  under(s.roots.Config),
+		langRoot:    under(s.roots.LangSpecific),
+	}, nil
+}
+
+func (s *ScopedStorage) Name() string {
+	return s.name
+}
+
+// topic 返回 topic 在父级中对应的 topic
+func (s *ScopedStorage) topic(topic string) (string, error) {
+	if err := checkTopic(s.root, topic); err != nil {
+		return "", err
+	}
+	return path.Join(s.name, filepath.ToSlash(topic)), nil
+}
+
+func (s *ScopedStorage) GetLoggerPath(topic string) string {
+	return confinedPath(s.logRoot, topic)
+}
+
+func (s *ScopedStorage) GetFileData(topic string) ([]byte, error) {
+	t, err := s.topic(topic)
+	if err != nil {
+		return nil, err
+	}
+	return s.parent.GetFileData(t)
+}
+
+func (s *ScopedStorage) GetJsonData(topic string, data interface{}) error {
+	t, err := s.topic(topic)
+	if err != nil {
+		return err
+	}
+	return s.parent.GetJsonData(t, data)
+}
+
+func (s *ScopedStorage) WriteFileData(topic string, data []byte) error {
+	t, err := s.topic(topic)
+	if err != nil {
+		return err
+	}
+	return s.parent.WriteFileData(t, data)
+}
+
+func (s *ScopedStorage) WriteJsonData(topic string, data interface{}) error {
+	t, err := s.topic(topic)
+	if err != nil {
+		return err
+	}
+	return s.parent.WriteJsonData(t, data)
+}
+
+func (s *ScopedStorage) WriteJsonDataWithTMP(topic string, tmpSuffix string, data interface{}) error {
+	if _, err := s.topic(topic + tmpSuffix); err != nil {
+		return err
+	}
+	t, err := s.topic(topic)
+	if err != nil {
+		return err
+	}
+	return s.parent.WriteJsonDataWithTMP(t, tmpSuffix, data)
+}
+
+func (s *ScopedStorage) BeginWrite() neomega_backbone.StagedWrite {
+	return &scopedStagedWrite{scope: s, inner: s.parent.BeginWrite()}
+}
+
+func (s *ScopedStorage) GetFilePath(elem ...string) string {
+	return confinedPath(s.root, elem...)
+}
+
+func (s *ScopedStorage) GetOmegaCachePath(elem ...string) string {
+	return confinedPath(s.cacheRoot, elem...)
+}
+
+func (s *ScopedStorage) GetArchivePath(elem ...string) string {
+	return confinedPath(s.archiveRoot, elem...)
+}
+
+func (s *ScopedStorage) GetConfigPath(elem ...string) string {
+	return confinedPath(s.configRoot, elem...)
+}
+
+// ResolveFilePath 与 GetFilePath 相同, 但路径经由符号链接逃出 ${data}/<name> 时返回 PathEscapeError
+func (s *ScopedStorage) ResolveFilePath(elem ...string) (string, error) {
+	return confine(s.root, elem...)
+}
+
+func (s *ScopedStorage) ResolveOmegaCachePath(elem ...string) (string, error) {
+	return confine(s.cacheRoot, elem...)
+}
+
+func (s *ScopedStorage) ResolveConfigPath(elem ...string) (string, error) {
+	return confine(s.configRoot, elem...)
+}
+
+// NewTempDir 创建的临时目录属于该组件, 组件停止时通过 ReleaseTempDirs 删除, 创建失败时与 StorageAndPath.NewTempDir 相同
+func (s *ScopedStorage) NewTempDir() string {
+	return s.parent.tempDirOrUnusable(s.name)
+}
+
+func (s *ScopedStorage) NewTempDirWithCleanup() (dir string, release func(), err error) {
+	if dir, err = s.parent.newTempDir(s.name); err != nil {
+		return "", nil, err
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+func (s *ScopedStorage) ReleaseTempDirs() error {
+	return s.parent.ReleaseTempDirs(s.name)
+}
+
+func (s *ScopedStorage) GetLangSpecificPath(elem ...string) string {
+	return confinedPath(s.langRoot, elem...)
+}
+
+// GetKVDBLike 中 saveDir 为相对路径时, 相对于 ${data}/<name>, 为绝对路径时也必须位于 ${data}/<name> 之下
+func (s *ScopedStorage) GetKVDBLike(saveDir string, dbType string) (neomega_backbone.KVDBLike, error) {
+	rel := saveDir
+	if filepath.IsAbs(saveDir) {
+		var err error
+		if rel, err = filepath.Rel(s.root, saveDir); err != nil {
+			return nil, &PathEscapeError{Root: s.root, Path: saveDir}
+		}
+	}
+	t, err := s.topic(rel)
+	if err != nil {
+		return nil, err
+	}
+	return s.parent.GetKVDBLike(t, dbType)
+}
+
+type scopedStagedWrite struct {
+	scope *ScopedStorage
+	inner neomega_backbone.StagedWrite
+	err   error
+}
+
+func (w *scopedStagedWrite) Write(topic string, data []byte) {
+	t, err := w.scope.topic(topic)
+	if err != nil {
+		if w.err == nil {
+			w.err = err
+		}
+		return
+	}
+	w.inner.Write(t, data)
+}
+
+func (w *scopedStagedWrite) WriteJson(topic string, data interface{}) error {
+	t, err := w.scope.topic(topic)
+	if err != nil {
+		return err
+	}
+	return w.inner.WriteJson(t, data)
+}
+
+func (w *scopedStagedWrite) Commit() error {
+	if w.err != nil {
+		w.inner.Abort()
+		return w.err
+	}
+	return w.inner.Commit()
+}
+
+func (w *scopedStagedWrite) Abort() {
+	w.inner.Abort()
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+// StagedWrite 的提交过程:
+// 1. 所有文件先写入 ${data}/.journal/<id>/<序号>
+// 2. 写入 ${data}/.journal/<id>/manifest.json(先写临时文件再 rename), 这是提交点
+// 3. 依次将 <序号> rename 为对应的 topic, 最后删除 ${data}/.journal/<id>
+// 恢复时, 有 manifest.json 的 journal 继续执行第 3 步, 没有的说明未提交, 直接删除
+const (
+	journalDir      = ".journal"
+	journalManifest = "manifest.json"
+)
+
+var ErrStagedWriteDone = errors.New("storage: staged write already committed or aborted")
+
+var journalSeq atomic.Uint64
+
+type manifest struct {
+	Topics []string `json:"topics"`
+}
+
+type StagedWrite struct {
+	s      *StorageAndPath
+	topics []string
+	data   map[string][]byte
+	done   bool
+	// Write 遇到的第一个错误, 由 Commit 返回
+	err error
+}
+
+func (s *StorageAndPath) BeginWrite() neomega_backbone.StagedWrite {
+	return &StagedWrite{s: s, data: map[string][]byte{}}
+}
+
+// Write 对同一个 topic 多次写入时, 以最后一次为准
+func (w *StagedWrite) Write(topic string, data []byte) {
+	if err := checkTopic(w.s.roots.Data, topic); err != nil {
+		if w.err == nil {
+			w.err = err
+		}
+		return
+	}
+	if _, ok := w.data[topic]; !ok {
+		w.topics = append(w.topics, topic)
+	}
+	w.data[topic] = data
+}
+
+func (w *StagedWrite) WriteJson(topic string, data interface{}) error {
+	raw, err := marshalJson(data)
+	if err != nil {
+		return err
+	}
+	w.Write(topic, raw)
+	return nil
+}
+
+func (w *StagedWrite) Abort() {
+	w.done = true
+	w.topics = nil
+	w.data = nil
+}
+
+func (w *StagedWrite) Commit() error {
+	if w.done {
+		return ErrStagedWriteDone
+	}
+	w.done = true
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.topics) == 0 {
+		return nil
+	}
+	s := w.s
+	releases := []func(){}
+	releaseAll := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+	for _, topic := range w.topics {
+		release, err := s.quota.reserveWrite(topic, int64(len(w.data[topic])))
+		if err != nil {
+			releaseAll()
+			return err
+		}
+		releases = append(releases, release)
+	}
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+	id := fmt.Sprintf("%v-%v", time.Now().UnixNano(), journalSeq.Add(1))
+	dir := path.Join(journalDir, id)
+	for i, topic := range w.topics {
+		if err := s.backend.WriteFile(path.Join(dir, strconv.Itoa(i)), w.data[topic]); err != nil {
+			s.removeJournal(dir)
+			releaseAll()
+			return err
+		}
+	}
+	raw, err := json.Marshal(manifest{Topics: w.topics})
+	if err == nil {
+		err = s.backend.WriteFile(path.Join(dir, journalManifest+".tmp"), raw)
+	}
+	if err == nil {
+		err = s.backend.Rename(path.Join(dir, journalManifest+".tmp"), path.Join(dir, journalManifest))
+	}
+	if err != nil {
+		s.removeJournal(dir)
+		releaseAll()
+		return err
+	}
+	// 已经提交, 之后的错误可以在下次启动时通过 RecoverStagedWrites 恢复
+	if err := s.applyJournal(dir); err != nil {
+		return err
+	}
+	for _, topic := range w.topics {
+		s.notifyWrite(topic)
+	}
+	return nil
+}
+
+// applyJournal 将已提交的 journal 中的文件移动到目标位置, 可以重复执行
+func (s *StorageAndPath) applyJournal(dir string) error {
+	raw, err := s.backend.ReadFile(path.Join(dir, journalManifest))
+	if err != nil {
+		return err
+	}
+	m := manifest{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return err
+	}
+	for i, topic := range m.Topics {
+		err := s.backend.Rename(path.Join(dir, strconv.Itoa(i)), topic)
+		// 上次恢复时已经移动过了
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return s.removeJournal(dir)
+}
+
+func (s *StorageAndPath) removeJournal(dir string) error {
+	names, _ := s.backend.ReadDir(dir)
+	// manifest 最后删除, 保证中途失败时 journal 依然被视为已提交
+	for _, name := range names {
+		if name != journalManifest {
+			s.backend.Remove(path.Join(dir, name))
+		}
+	}
+	s.backend.Remove(path.Join(dir, journalManifest))
+	err := s.backend.Remove(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// RecoverStagedWrites 完成上次已提交但未完成的 StagedWrite, 并丢弃未提交的
+func (s *StorageAndPath) RecoverStagedWrites() error {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+	ids, err := s.backend.ReadDir(journalDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, id := range ids {
+		dir := path.Join(journalDir, id)
+		if _, err := s.backend.ReadFile(path.Join(dir, journalManifest)); err == nil {
+			err = s.applyJournal(dir)
+		} else {
+			err = s.removeJournal(dir)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
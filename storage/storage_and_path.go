@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+	"github.com/OmineDev/neomega-backbone/file_log_kvdb"
+	"github.com/OmineDev/neomega-backbone/file_watcher"
+)
+
+// Roots 是 StorageAndPathAccess 中各个 ${xxx} 对应的目录
+type Roots struct {
+	Log          string
+	Data         string
+	Cache        string
+	Archive      string
+	Config       string
+	Temp         string
+	LangSpecific string
+}
+
+// RootsUnder 返回以 base 为根目录的一组默认目录
+func RootsUnder(base string) Roots {
+	return Roots{
+		Log:          filepath.Join(base, "logs"),
+		Data:         filepath.Join(base, "data"),
+		Cache:        filepath.Join(base, "cache"),
+		Archive:      filepath.Join(base, "archive"),
+		Config:       filepath.Join(base, "config"),
+		Temp:         filepath.Join(base, "temp"),
+		LangSpecific: filepath.Join(base, "lang"),
+	}
+}
+
+// StorageAndPath 是基于本地文件系统的 StorageAndPathProvider 实现
+// ${data}/topic 的读写(GetFileData/WriteFileData/...)通过 StorageBackend 进行,
+// 但 GetFilePath 等返回路径的方法总是指向本地目录
+type StorageAndPath struct {
+	roots   Roots
+	backend StorageBackend
+	// 每次 ${data}/topic 被写入后调用, 用于 TempStorage 记录写入
+	onWrite func(topic string)
+	// 保证 StagedWrite 的提交和恢复是串行的
+	journalMu sync.Mutex
+	quota     *quotaManager
+	// 本进程的临时目录位于 ${temp}/<tempSession> 下
+	tempSession string
+
+	kvdbMu sync.Mutex
+	kvdbs  map[string]neomega_backbone.KVDBLike
+
+	watchMu sync.Mutex
+	watcher *file_watcher.Watcher
+
+	// PreInit 之后用于输出无法返回的错误, 见 reportError
+	out atomic.Pointer[neomega_backbone.MultiOutDst]
+}
+
+var (
+	_ neomega_backbone.StorageAndPathProvider                 = (*StorageAndPath)(nil)
+	_ neomega_backbone.StorageAndPathAccessWithStagedWrite    = (*StorageAndPath)(nil)
+	_ neomega_backbone.StorageAndPathAccessWithTempDirCleanup = (*StorageAndPath)(nil)
+	_ neomega_backbone.StorageAndPathAccessWithWatch          = (*StorageAndPath)(nil)
+)
+
+func NewStorageAndPath(roots Roots) *StorageAndPath {
+	return NewStorageAndPathWithBackend(roots, NewLocalBackend(roots.Data))
+}
+
+func NewStorageAndPathWithBackend(roots Roots, backend StorageBackend) *StorageAndPath {
+	return &StorageAndPath{
+		roots:       roots,
+		backend:     backend,
+		quota:       newQuotaManager(roots, backend),
+		tempSession: newTempSession(),
+		kvdbs:       map[string]neomega_backbone.KVDBLike{},
+	}
+}
+
+func (s *StorageAndPath) Roots() Roots {
+	return s.roots
+}
+
+// PreInit 会恢复上次未完成的 StagedWrite, 并清理上次运行留下的临时目录
+func (s *StorageAndPath) PreInit(omega neomega_backbone.PreInitOmega) error {
+	if omega != nil {
+		s.out.Store(omega.Out())
+	}
+	if err := s.CleanStaleTempDirs(); err != nil {
+		return err
+	}
+	return s.RecoverStagedWrites()
+}
+
+// reportError 输出在签名无法返回错误的方法中发生的错误(e.g. NewTempDir), PreInit 之前输出到 stderr
+func (s *StorageAndPath) reportError(err error) {
+	if out := s.out.Load(); out != nil {
+		out.Error.Printfln("%v", err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+}
+
+// 所有返回路径的方法都会将参数限制在对应的根目录下, e.g. GetFilePath("../../a") 返回 ${data}/a
+// 经由符号链接逃出根目录的路径会被替换为无法使用的路径, 需要得到 PathEscapeError 时使用 ResolveFilePath 等方法
+// 读写数据的方法则会对试图跳出 ${data} 的 topic 返回 PathEscapeError
+
+func (s *StorageAndPath) GetLoggerPath(topic string) string {
+	return confinedPath(s.roots.Log, topic)
+}
+
+func (s *StorageAndPath) Backend() StorageBackend {
+	return s.backend
+}
+
+func (s *StorageAndPath) GetFileData(topic string) ([]byte, error) {
+	if err := checkTopic(s.roots.Data, topic); err != nil {
+		return nil, err
+	}
+	return s.backend.ReadFile(topic)
+}
+
+func (s *StorageAndPath) GetJsonData(topic string, data interface{}) error {
+	raw, err := s.GetFileData(topic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, data)
+}
+
+func (s *StorageAndPath) notifyWrite(topic string) {
+	if s.onWrite != nil {
+		s.onWrite(topic)
+	}
+}
+
+func (s *StorageAndPath) WriteFileData(topic string, data []byte) error {
+	if err := checkTopic(s.roots.Data, topic); err != nil {
+		return err
+	}
+	release, err := s.quota.reserveWrite(topic, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	if err := s.backend.WriteFile(topic, data); err != nil {
+		release()
+		return err
+	}
+	s.notifyWrite(topic)
+	return nil
+}
+
+func marshalJson(data interface{}) ([]byte, error) {
+	return json.MarshalIndent(data, "", "\t")
+}
+
+func (s *StorageAndPath) WriteJsonData(topic string, data interface{}) error {
+	raw, err := marshalJson(data)
+	if err != nil {
+		return err
+	}
+	return s.WriteFileData(topic, raw)
+}
+
+// WriteJsonDataWithTMP 先写入 topic+tmpSuffix, 再 rename 为 topic, 避免写入中断时破坏原文件
+func (s *StorageAndPath) WriteJsonDataWithTMP(topic string, tmpSuffix string, data interface{}) error {
+	if err := checkTopic(s.roots.Data, topic); err != nil {
+		return err
+	}
+	if err := checkTopic(s.roots.Data, topic+tmpSuffix); err != nil {
+		return err
+	}
+	raw, err := marshalJson(data)
+	if err != nil {
+		return err
+	}
+	release, err := s.quota.reserveWrite(topic, int64(len(raw)))
+	if err != nil {
+		return err
+	}
+	if err := s.backend.WriteFile(topic+tmpSuffix, raw); err != nil {
+		release()
+		return err
+	}
+	if err := s.backend.Rename(topic+tmpSuffix, topic); err != nil {
+		release()
+		return err
+	}
+	s.notifyWrite(topic)
+	return nil
+}
+
+func (s *StorageAndPath) GetFilePath(elem ...string) string {
+	return confinedPath(s.roots.Data, elem...)
+}
+
+func (s *StorageAndPath) GetOmegaCachePath(elem ...string) string {
+	return confinedPath(s.roots.Cache, elem...)
+}
+
+func (s *StorageAndPath) GetArchivePath(elem ...string) string {
+	return confinedPath(s.roots.Archive, elem...)
+}
+
+func (s *StorageAndPath) GetConfigPath(elem ...string) string {
+	return confinedPath(s.roots.Config, elem...)
+}
+
+func (s *StorageAndPath) GetLangSpecificPath(elem ...string) string {
+	return confinedPath(s.roots.LangSpecific, elem...)
+}
+
+// ResolveFilePath 与 GetFilePath 相同, 但路径经由符号链接逃出 ${data} 时返回 PathEscapeError
+func (s *StorageAndPath) ResolveFilePath(elem ...string) (string, error) {
+	return confine(s.roots.Data, elem...)
+}
+
+// ResolveOmegaCachePath 与 GetOmegaCachePath 相同, 但路径经由符号链接逃出 ${cache} 时返回 PathEscapeError
+func (s *StorageAndPath) ResolveOmegaCachePath(elem ...string) (string, error) {
+	return confine(s.roots.Cache, elem...)
+}
+
+// ResolveConfigPath 与 GetConfigPath 相同, 但路径经由符号链接逃出 ${config} 时返回 PathEscapeError
+func (s *StorageAndPath) ResolveConfigPath(elem ...string) (string, error) {
+	return confine(s.roots.Config, elem...)
+}
+
+// GetKVDBLike 中 saveDir 为相对路径时, 相对于 ${data}, 为绝对路径时也必须位于 ${data} 之下
+// 同一个目录只会被打开一次, 重复获取时返回同一个实例
+func (s *StorageAndPath) GetKVDBLike(saveDir string, dbType string) (neomega_backbone.KVDBLike, error) {
+	rel := saveDir
+	if filepath.IsAbs(saveDir) {
+		var err error
+		if rel, err = filepath.Rel(s.roots.Data, saveDir); err != nil {
+			return nil, &PathEscapeError{Root: s.roots.Data, Path: saveDir}
+		}
+	}
+	if err := checkTopic(s.roots.Data, rel); err != nil {
+		return nil, err
+	}
+	saveDir, err := ResolvePath(s.roots.Data, rel)
+	if err != nil {
+		return nil, err
+	}
+	s.kvdbMu.Lock()
+	defer s.kvdbMu.Unlock()
+	if db, ok := s.kvdbs[saveDir]; ok {
+		return db, nil
+	}
+	switch dbType {
+	case file_log_kvdb.DBType, "":
+		opts := file_log_kvdb.DefaultOptions()
+		component := componentOf(filepath.ToSlash(rel))
+		opts.WriteGuard = func(n int64) error {
+			return s.quota.reserve(quotaRootData, component, n)
+		}
+		db, err := file_log_kvdb.NewFileLogKVDBWithOptions(saveDir, opts)
+		if err != nil {
+			return nil, err
+		}
+		s.kvdbs[saveDir] = db
+		return db, nil
+	default:
+		return nil, fmt.Errorf("storage: unknown kvdb type %v", dbType)
+	}
+}
+
+// Close 关闭所有通过 GetKVDBLike 打开的数据库, 并停止定期清理超出配额的缓存
+func (s *StorageAndPath) Close() error {
+	s.quota.stop()
+	return s.closeKVDBs()
+}
+
+func (s *StorageAndPath) closeKVDBs() error {
+	s.kvdbMu.Lock()
+	defer s.kvdbMu.Unlock()
+	var firstErr error
+	for dir, db := range s.kvdbs {
+		if closer, ok := db.(interface{ Close() error }); ok {
+			if err := closer.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		delete(s.kvdbs, dir)
+	}
+	return firstErr
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 临时目录的布局:
+// ${temp}/<pid>-<启动时间>/shared/<随机名>          未指定所有者的临时目录
+// ${temp}/<pid>-<启动时间>/components/<组件>/<随机名> ScopedStorage 创建的临时目录
+// 组件停止时可以通过 ReleaseTempDirs 删除其所有临时目录,
+// 程序被直接杀死时留下的目录会在下次启动时由 CleanStaleTempDirs 删除
+
+func newTempSession() string {
+	return fmt.Sprintf("%v-%v", os.Getpid(), time.Now().UnixNano())
+}
+
+func (s *StorageAndPath) sessionDir() string {
+	return filepath.Join(s.roots.Temp, s.tempSession)
+}
+
+func (s *StorageAndPath) ownerDir(owner string) string {
+	if owner == "" {
+		return filepath.Join(s.sessionDir(), "shared")
+	}
+	return filepath.Join(s.sessionDir(), "components", filepath.FromSlash(owner))
+}
+
+func (s *StorageAndPath) newTempDir(owner string) (string, error) {
+	parent := s.ownerDir(owner)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(parent, "")
+}
+
+// NewTempDir 的签名无法返回错误, 创建失败(e.g. 磁盘已满)时记录错误并返回无法使用的路径, 对它的任何文件操作都会失败
+// 需要处理错误时使用 NewTempDirWithCleanup
+func (s *StorageAndPath) NewTempDir() string {
+	return s.tempDirOrUnusable("")
+}
+
+// unusableTempDir 与 escapedPath 相同, 其中包含 NUL, 对它以及由它拼接出的路径的任何文件操作都会失败
+const unusableTempDir = "\x00temp-dir-unavailable"
+
+func (s *StorageAndPath) tempDirOrUnusable(owner string) string {
+	dir, err := s.newTempDir(owner)
+	if err != nil {
+		s.reportError(fmt.Errorf("storage: create temp dir: %w", err))
+		return filepath.Join(s.ownerDir(owner), unusableTempDir)
+	}
+	return dir
+}
+
+// NewTempDirWithCleanup 返回一个临时目录和删除它的函数
+func (s *StorageAndPath) NewTempDirWithCleanup() (dir string, release func(), err error) {
+	if dir, err = s.newTempDir(""); err != nil {
+		return "", nil, err
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+// ReleaseTempDirs 删除 owner(ScopedStorage 的 name)创建的所有临时目录, 应在组件停止时调用
+func (s *StorageAndPath) ReleaseTempDirs(owner string) error {
+	return os.RemoveAll(s.ownerDir(owner))
+}
+
+// ReleaseAllTempDirs 删除本进程创建的所有临时目录, 应在程序正常退出时调用
+func (s *StorageAndPath) ReleaseAllTempDirs() error {
+	return os.RemoveAll(s.sessionDir())
+}
+
+// CleanStaleTempDirs 删除已经不在运行的进程留下的临时目录
+func (s *StorageAndPath) CleanStaleTempDirs() error {
+	entries, err := os.ReadDir(s.roots.Temp)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || name == s.tempSession {
+			continue
+		}
+		pidStr, _, ok := strings.Cut(name, "-")
+		if !ok {
+			continue
+		}
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil {
+			continue
+		}
+		if pid != os.Getpid() && processAlive(pid) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.roots.Temp, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+// TempStorage 是根目录位于临时目录下的 StorageAndPath, 用于测试 DynamicComponent
+// e.g.
+//
+//	s, _ := storage.NewTempStorage()
+//	defer s.Cleanup()
+//	component.Init(cfg, s)
+//	...
+//	data, _ := s.ReadData("my_component/data.json")
+type TempStorage struct {
+	*StorageAndPath
+	base string
+
+	mu     sync.Mutex
+	writes []string
+}
+
+var _ neomega_backbone.StorageAndPathProvider = (*TempStorage)(nil)
+
+func NewTempStorage() (*TempStorage, error) {
+	base, err := os.MkdirTemp("", "neomega-storage-")
+	if err != nil {
+		return nil, err
+	}
+	roots := RootsUnder(base)
+	for _, dir := range []string{roots.Log, roots.Data, roots.Cache, roots.Archive, roots.Config, roots.Temp, roots.LangSpecific} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			os.RemoveAll(base)
+			return nil, err
+		}
+	}
+	s := &TempStorage{
+		StorageAndPath: NewStorageAndPath(roots),
+		base:           base,
+	}
+	s.StorageAndPath.onWrite = s.recordWrite
+	return s, nil
+}
+
+func (s *TempStorage) Base() string {
+	return s.base
+}
+
+// Cleanup 关闭所有数据库, 停止所有监听并删除整个临时目录
+func (s *TempStorage) Cleanup() error {
+	s.StorageAndPath.Close()
+	s.StorageAndPath.StopWatching()
+	return os.RemoveAll(s.base)
+}
+
+func (s *TempStorage) recordWrite(topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes = append(s.writes, topic)
+}
+
+// Writes 按顺序返回被写入过的 topic, 包括 WriteFileData/WriteJsonData/WriteJsonDataWithTMP 和 StagedWrite
+func (s *TempStorage) Writes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.writes...)
+}
+
+// ReadData 读取 ${data}/topic, 不存在时返回 nil
+func (s *TempStorage) ReadData(topic string) []byte {
+	data, err := s.GetFileData(topic)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// Files 返回 root 下所有文件相对于 root 的路径(使用 / 分隔), root 通常是 Roots() 中的一个目录
+func (s *TempStorage) Files(root string) []string {
+	files := []string{}
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err == nil {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+// DataFiles 返回 ${data} 下的所有文件
+func (s *TempStorage) DataFiles() []string {
+	return s.Files(s.roots.Data)
+}
+
+// KVDBSnapshot 返回通过 GetKVDBLike 打开的 saveDir 数据库中的所有数据, 数据库未被打开时返回 nil
+func (s *TempStorage) KVDBSnapshot(saveDir string) map[string]string {
+	if !filepath.IsAbs(saveDir) {
+		saveDir = s.GetFilePath(saveDir)
+	}
+	s.kvdbMu.Lock()
+	db, ok := s.kvdbs[filepath.Clean(saveDir)]
+	s.kvdbMu.Unlock()
+	if !ok {
+		return nil
+	}
+	snapshot := map[string]string{}
+	db.Iter(func(key, value string) bool {
+		snapshot[key] = value
+		return true
+	})
+	return snapshot
+}
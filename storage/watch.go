@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/OmineDev/neomega-backbone/file_watcher"
+)
+
+const (
+	watchDebounce     = 300 * time.Millisecond
+	watchPollInterval = 2 * time.Second
+)
+
+// Watch 监听 path 的变化, path 必须位于某个 ${xxx} 目录之下
+// 在 linux 上使用 inotify, 其他平台或 inotify 不可用时(e.g. android 公共目录)退化为轮询
+func (s *StorageAndPath) Watch(path string, cb func(changed string)) (cancel func(), err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if !s.inRoots(path) {
+		return nil, &PathEscapeError{Root: filepath.Dir(s.roots.Data), Path: path}
+	}
+	return s.getWatcher().Watch(path, cb)
+}
+
+func (s *StorageAndPath) inRoots(path string) bool {
+	for _, root := range []string{s.roots.Log, s.roots.Data, s.roots.Cache, s.roots.Archive, s.roots.Config, s.roots.Temp, s.roots.LangSpecific} {
+		if root == "" {
+			continue
+		}
+		if absRoot, err := filepath.Abs(root); err == nil && within(absRoot, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *StorageAndPath) getWatcher() *file_watcher.Watcher {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	if s.watcher == nil {
+		s.watcher = file_watcher.NewWatcher(watchDebounce, watchPollInterval)
+	}
+	return s.watcher
+}
+
+// StopWatching 停止所有通过 Watch 建立的监听
+// Close 不会停止监听, 因为 RestoreSnapshot 恢复的配置文件同样需要被发现
+func (s *StorageAndPath) StopWatching() error {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	if s.watcher == nil {
+		return nil
+	}
+	err := s.watcher.Close()
+	s.watcher = nil
+	return err
+}
+
+// Watch 只允许监听 ${data}/<name>/, ${cache}/<name>/ 以及 ${config}/<name>/ 下的路径
+func (s *ScopedStorage) Watch(path string, cb func(changed string)) (cancel func(), err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range []string{s.root, s.cacheRoot, s.configRoot} {
+		absRoot, err := filepath.Abs(root)
+		if err != nil || !within(absRoot, path) {
+			continue
+		}
+		rel, _ := filepath.Rel(absRoot, path)
+		if _, err := confine(absRoot, rel); err != nil {
+			return nil, err
+		}
+		return s.parent.getWatcher().Watch(path, cb)
+	}
+	return nil, &PathEscapeError{Root: s.root, Path: path}
+}
@@ -0,0 +1,58 @@
+package typed_kv
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Codec 决定了值以何种格式保存在 KVDBLike 中
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JsonCodec 保存为 json 文本, 便于人工查看和修改
+	JsonCodec Codec = jsonCodec{}
+	// GobCodec 和 MsgpackCodec 保存为二进制数据, 需要底层的 KVDBLike 能保存任意字节(FileLogKVDB 可以)
+	GobCodec     Codec = gobCodec{}
+	MsgpackCodec Codec = msgpackCodec{handle: &codec.MsgpackHandle{}}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct {
+	handle *codec.MsgpackHandle
+}
+
+func (c msgpackCodec) Marshal(v any) (data []byte, err error) {
+	err = codec.NewEncoderBytes(&data, c.handle).Encode(v)
+	return data, err
+}
+
+func (c msgpackCodec) Unmarshal(data []byte, v any) error {
+	return codec.NewDecoderBytes(data, c.handle).Decode(v)
+}
@@ -0,0 +1,79 @@
+package typed_kv
+
+import (
+	"sync"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+// TypedKV 在 KVDBLike 之上保存结构化数据, 值通过 Codec 编码后保存
+// e.g.
+//
+//	balances := typed_kv.NewTypedKV[PlayerBalance](db, typed_kv.JsonCodec)
+//	balances.Update(uuid, func(b PlayerBalance, found bool) (PlayerBalance, error) {
+//		b.Coins += 10
+//		return b, nil
+//	})
+type TypedKV[T any] struct {
+	db    neomega_backbone.KVDBLike
+	codec Codec
+	// 保证同一个 TypedKV 上的 Update 是串行的
+	mu sync.Mutex
+}
+
+func NewTypedKV[T any](db neomega_backbone.KVDBLike, codec Codec) *TypedKV[T] {
+	if codec == nil {
+		codec = JsonCodec
+	}
+	return &TypedKV[T]{db: db, codec: codec}
+}
+
+// Get 中 found 表示 key 是否存在, 存在但无法解码时返回 err
+func (t *TypedKV[T]) Get(key string) (val T, found bool, err error) {
+	raw := t.db.Get(key)
+	if raw == "" {
+		return val, false, nil
+	}
+	err = t.codec.Unmarshal([]byte(raw), &val)
+	return val, true, err
+}
+
+func (t *TypedKV[T]) Set(key string, val T) error {
+	data, err := t.codec.Marshal(val)
+	if err != nil {
+		return err
+	}
+	t.db.Set(key, string(data))
+	return nil
+}
+
+func (t *TypedKV[T]) Delete(key string) {
+	t.db.Delete(key)
+}
+
+// Update 读取 key 当前的值交给 fn 修改后写回, fn 返回 err 时不写回
+func (t *TypedKV[T]) Update(key string, fn func(val T, found bool) (T, error)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	val, found, err := t.Get(key)
+	if err != nil {
+		return err
+	}
+	val, err = fn(val, found)
+	if err != nil {
+		return err
+	}
+	return t.Set(key, val)
+}
+
+// Iter 遍历所有 key, 遇到无法解码的值时停止遍历并返回 err
+func (t *TypedKV[T]) Iter(fn func(key string, val T) bool) (err error) {
+	t.db.Iter(func(key, raw string) bool {
+		var val T
+		if err = t.codec.Unmarshal([]byte(raw), &val); err != nil {
+			return false
+		}
+		return fn(key, val)
+	})
+	return err
+}
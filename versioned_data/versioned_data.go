@@ -0,0 +1,175 @@
+package versioned_data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	neomega_backbone "github.com/OmineDev/neomega-backbone"
+)
+
+// envelope 是带版本的数据在文件中的格式
+// 没有 envelope 的旧文件(直接由 StorageAndPathAccess.WriteJsonData 写入的)被视为版本 0
+type envelope struct {
+	Version int             `json:"数据版本"`
+	Data    json.RawMessage `json:"数据"`
+}
+
+// MigrationFn 将数据从 fromVersion 升级到 fromVersion+1
+type MigrationFn func(old json.RawMessage) (new json.RawMessage, err error)
+
+type MigrationPlan struct {
+	Topic       string
+	FromVersion int
+	ToVersion   int
+}
+
+type Registry struct {
+	mu         sync.RWMutex
+	migrations map[string]map[int]MigrationFn
+	// 写入时使用的临时文件后缀, 见 StorageAndPathAccess.WriteJsonDataWithTMP
+	tmpSuffix string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		migrations: map[string]map[int]MigrationFn{},
+		tmpSuffix:  ".tmp",
+	}
+}
+
+// RegisterMigration 注册 topic 从 fromVersion 升级到 fromVersion+1 的迁移函数
+// topic 的当前版本为所有已注册迁移中最大的 fromVersion+1
+func (r *Registry) RegisterMigration(topic string, fromVersion int, fn MigrationFn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.migrations[topic] == nil {
+		r.migrations[topic] = map[int]MigrationFn{}
+	}
+	r.migrations[topic][fromVersion] = fn
+}
+
+func (r *Registry) CurrentVersion(topic string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.currentVersion(topic)
+}
+
+func (r *Registry) currentVersion(topic string) int {
+	version := 0
+	for from := range r.migrations[topic] {
+		if from+1 > version {
+			version = from + 1
+		}
+	}
+	return version
+}
+
+func decode(raw []byte) envelope {
+	e := envelope{}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&e); err != nil || e.Data == nil {
+		return envelope{Version: 0, Data: raw}
+	}
+	return e
+}
+
+// migrate 将 e 升级到 topic 的当前版本
+func (r *Registry) migrate(topic string, e envelope) (envelope, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	current := r.currentVersion(topic)
+	if e.Version > current {
+		return e, fmt.Errorf("versioned_data: %v has version %v, newer than current version %v", topic, e.Version, current)
+	}
+	for e.Version < current {
+		fn, ok := r.migrations[topic][e.Version]
+		if !ok {
+			return e, fmt.Errorf("versioned_data: no migration registered for %v from version %v", topic, e.Version)
+		}
+		data, err := fn(e.Data)
+		if err != nil {
+			return e, fmt.Errorf("versioned_data: migrate %v from version %v: %w", topic, e.Version, err)
+		}
+		e = envelope{Version: e.Version + 1, Data: data}
+	}
+	return e, nil
+}
+
+// GetJsonData 读取 ${data}/topic, 若其版本低于当前版本, 则逐级迁移并通过 WriteJsonDataWithTMP 写回
+func (r *Registry) GetJsonData(storage neomega_backbone.StorageAndPathAccess, topic string, data any) error {
+	raw, err := storage.GetFileData(topic)
+	if err != nil {
+		return err
+	}
+	e := decode(raw)
+	oldVersion := e.Version
+	if e, err = r.migrate(topic, e); err != nil {
+		return err
+	}
+	if e.Version != oldVersion {
+		if err := storage.WriteJsonDataWithTMP(topic, r.tmpSuffix, e); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(e.Data, data)
+}
+
+// WriteJsonData 以当前版本写入 ${data}/topic
+func (r *Registry) WriteJsonData(storage neomega_backbone.StorageAndPathAccess, topic string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return storage.WriteJsonDataWithTMP(topic, r.tmpSuffix, envelope{Version: r.CurrentVersion(topic), Data: raw})
+}
+
+// DryRun 检查 topics 中哪些需要迁移, 但不执行迁移, topics 为空时检查所有注册了迁移的 topic
+// 不存在的 topic 会被跳过
+func (r *Registry) DryRun(storage neomega_backbone.StorageAndPathAccess, topics ...string) ([]MigrationPlan, error) {
+	if len(topics) == 0 {
+		r.mu.RLock()
+		for topic := range r.migrations {
+			topics = append(topics, topic)
+		}
+		r.mu.RUnlock()
+		sort.Strings(topics)
+	}
+	plans := []MigrationPlan{}
+	for _, topic := range topics {
+		raw, err := storage.GetFileData(topic)
+		if err != nil {
+			continue
+		}
+		from := decode(raw).Version
+		to := r.CurrentVersion(topic)
+		if from > to {
+			return plans, fmt.Errorf("versioned_data: %v has version %v, newer than current version %v", topic, from, to)
+		}
+		if from != to {
+			plans = append(plans, MigrationPlan{Topic: topic, FromVersion: from, ToVersion: to})
+		}
+	}
+	return plans, nil
+}
+
+var DefaultRegistry = NewRegistry()
+
+func RegisterMigration(topic string, fromVersion int, fn MigrationFn) {
+	DefaultRegistry.RegisterMigration(topic, fromVersion, fn)
+}
+
+func GetJsonData(storage neomega_backbone.StorageAndPathAccess, topic string, data any) error {
+	return DefaultRegistry.GetJsonData(storage, topic, data)
+}
+
+func WriteJsonData(storage neomega_backbone.StorageAndPathAccess, topic string, data any) error {
+	return DefaultRegistry.WriteJsonData(storage, topic, data)
+}
+
+func DryRun(storage neomega_backbone.StorageAndPathAccess, topics ...string) ([]MigrationPlan, error) {
+	return DefaultRegistry.DryRun(storage, topics...)
+}